@@ -0,0 +1,178 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// The address a service registers is chosen by, in order of precedence:
+//
+//	ADVERTISE_ADDR       an explicit IP or hostname, used as-is
+//	ADVERTISE_INTERFACE  take the address of this interface, e.g. eth0
+//	ADVERTISE_CIDR       only consider addresses in these networks,
+//	                     comma separated, e.g. 192.168.4.0/22
+//	ADVERTISE_FAMILY     ipv4 (default), ipv6 or any
+//
+// Without an interface, bridge and tunnel interfaces created by Docker and
+// friends are skipped, so the host's own address wins over docker0.
+
+// virtualInterfacePrefixes name interfaces that never carry the host's
+// advertised address unless selected explicitly.
+var virtualInterfacePrefixes = []string{"docker", "br-", "veth", "virbr", "cni", "flannel", "cali", "vxlan", "tun", "tap", "zt"}
+
+// AddressSelector picks the address a service advertises to the registry.
+type AddressSelector struct {
+	Address   string       // explicit address; skips interface discovery
+	Interface string       // only use this interface
+	CIDRs     []*net.IPNet // only use addresses inside one of these networks
+	Family    string       // "ipv4", "ipv6" or "any"
+}
+
+// AddressSelectorFromEnv builds a selector from the ADVERTISE_* variables.
+func AddressSelectorFromEnv() (*AddressSelector, error) {
+	s := &AddressSelector{
+		Address:   strings.TrimSpace(GetEnv("ADVERTISE_ADDR", "")),
+		Interface: strings.TrimSpace(GetEnv("ADVERTISE_INTERFACE", "")),
+		Family:    strings.ToLower(strings.TrimSpace(GetEnv("ADVERTISE_FAMILY", ""))),
+	}
+	if s.Family == "" {
+		s.Family = "ipv4" // unset or empty, e.g. ADVERTISE_FAMILY= in .env
+	}
+
+	switch s.Family {
+	case "ipv4", "ipv6", "any":
+	default:
+		return nil, fmt.Errorf("%w: ADVERTISE_FAMILY must be ipv4, ipv6 or any, got %q", ErrInvalidConfig, s.Family)
+	}
+
+	for _, cidr := range strings.Split(GetEnv("ADVERTISE_CIDR", ""), ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid ADVERTISE_CIDR %q: %v", ErrInvalidConfig, cidr, err)
+		}
+		s.CIDRs = append(s.CIDRs, network)
+	}
+
+	return s, nil
+}
+
+// AdvertiseAddress resolves the address to register using the ADVERTISE_* variables.
+func AdvertiseAddress() (string, error) {
+	s, err := AddressSelectorFromEnv()
+	if err != nil {
+		return "", err
+	}
+	return s.Resolve()
+}
+
+// Resolve returns the explicit address if set, otherwise the first usable
+// address of an interface that is up and matches the selector.
+func (s *AddressSelector) Resolve() (string, error) {
+	if s.Address != "" {
+		return s.Address, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+
+	found := false
+	for _, iface := range ifaces {
+		if s.Interface != "" {
+			if iface.Name != s.Interface {
+				continue
+			}
+			found = true
+		} else if isVirtualInterface(iface.Name) {
+			continue
+		}
+		if iface.Flags&net.FlagUp == 0 {
+			continue // interface down
+		}
+		if iface.Flags&net.FlagLoopback != 0 && s.Interface == "" {
+			continue // loopback interface
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return "", fmt.Errorf("failed to list addresses of %s: %w", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			if ip := s.usable(addr); ip != nil {
+				return ip.String(), nil
+			}
+		}
+	}
+
+	if s.Interface != "" && !found {
+		return "", fmt.Errorf("%w: network interface %s", ErrNotFound, s.Interface)
+	}
+	return "", fmt.Errorf("%w: no %s address matches %s", ErrNotFound, s.Family, s.describe())
+}
+
+// usable returns the IP of addr if the selector accepts it.
+func (s *AddressSelector) usable(addr net.Addr) net.IP {
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.IPNet:
+		ip = v.IP
+	case *net.IPAddr:
+		ip = v.IP
+	}
+	if ip == nil || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return nil
+	}
+	if ip.IsLoopback() && s.Interface == "" {
+		return nil
+	}
+
+	isIPv4 := ip.To4() != nil
+	switch {
+	case s.Family == "ipv4" && !isIPv4:
+		return nil
+	case s.Family == "ipv6" && isIPv4:
+		return nil
+	}
+	if isIPv4 {
+		ip = ip.To4()
+	}
+
+	if len(s.CIDRs) == 0 {
+		return ip
+	}
+	for _, network := range s.CIDRs {
+		if network.Contains(ip) {
+			return ip
+		}
+	}
+	return nil
+}
+
+func (s *AddressSelector) describe() string {
+	var parts []string
+	if s.Interface != "" {
+		parts = append(parts, "interface "+s.Interface)
+	}
+	for _, network := range s.CIDRs {
+		parts = append(parts, "network "+network.String())
+	}
+	if len(parts) == 0 {
+		return "any non-virtual interface"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func isVirtualInterface(name string) bool {
+	for _, prefix := range virtualInterfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
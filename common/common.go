@@ -5,46 +5,33 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
+	"log/slog"
 	"net/http"
-	"os"
 	"time"
-
-	"github.com/fatih/color"
 )
 
+// The helpers below are thin wrappers around Log; see log.go.
 var (
-	Head  = newlinePrintfFunc(color.New(color.FgHiMagenta).Add(color.Bold).Add(color.Underline).PrintfFunc())
-	Out   = newlinePrintfFunc(color.New(color.FgHiWhite).PrintfFunc())
-	Info  = newlinePrintfFunc(color.New(color.FgCyan).PrintfFunc())
-	Warn  = newlinePrintfFunc(color.New(color.FgHiYellow).Add(color.Bold).PrintfFunc())
-	Err   = errorPrintfFunc(color.New(color.FgHiRed).Add(color.Bold).PrintfFunc())
-	Fatal = func(format string, args ...interface{}) {
-		msg := fmt.Sprintf(format, args...)
-		color.New(color.FgHiRed).Add(color.Bold).Add(color.BgBlack).Println(msg)
-		os.Exit(1) // Terminate the program
-	}
-	Ok              = newlinePrintfFunc(color.New(color.FgHiGreen).PrintfFunc())
+	Head            = logFunc(slog.LevelInfo, "head")
+	Out             = logFunc(slog.LevelInfo, "out")
+	Info            = logFunc(slog.LevelInfo, "")
+	Warn            = logFunc(slog.LevelWarn, "")
+	Err             = errorLogFunc(slog.LevelError, "")
+	Fatal           = fatalLogFunc(slog.LevelError, "fatal")
+	Ok              = logFunc(slog.LevelInfo, "ok")
 	TELEGRAM_TOKEN  string
 	CHAT_ID         string
 	PYTHON_API_HOST string
 	REGISTRY_HOST   string
+	REGISTRY_SECRET string
 )
 
-func newlinePrintfFunc(f func(format string, a ...interface{})) func(format string, a ...interface{}) {
-	return func(format string, a ...interface{}) {
-		f(format+"\n", a...)
-	}
-}
-
-func errorPrintfFunc(f func(format string, a ...interface{})) func(format string, a ...interface{}) error {
-	return func(format string, a ...interface{}) error {
-		fmt.Println()
-		f(format+"\n", a...)
-		return fmt.Errorf(format, a...)
-	}
-}
+// DefaultRegistryHost is used when REGISTRY_HOST is unset or empty, as
+// the services did before the host became configurable.
+const DefaultRegistryHost = "192.168.4.63"
 
+// FailError logs and exits when err is not nil. It is meant for a service's
+// main; library code returns errors instead so callers can retry or degrade.
 func FailError(err error, format string, args ...interface{}) {
 	if err != nil {
 		Err(format, args...)
@@ -53,60 +40,128 @@ func FailError(err error, format string, args ...interface{}) {
 
 }
 
-// SendMessageToChat sends a message to the chat using the Python API.
+// SendMessageToTelegram sends a message through the default notifier.
+// Kept for existing call sites; delivery is asynchronous, see Notify.
 func SendMessageToTelegram(message string) {
-	time.Sleep(1 * time.Second)
-	// Create the JSON payload
-	payload := map[string]string{"message": message}
-	jsonData, err := json.Marshal(payload)
+	Notify(message)
+}
+
+// RegisterService registers the service with the registry and returns the granted lease.
+// Services with a LeaseTTL must keep the lease alive with Heartbeat.
+func RegisterService(service *ServiceConfig) (*Lease, error) {
+	// Marshal the updated service data
+	updatedJsonData, err := json.Marshal(service)
 	if err != nil {
-		Warn("Error marshalling JSON data: %v", err)
+		return nil, fmt.Errorf("error marshalling updated service data: %w", err)
 	}
 
-	// Get the Python API host from environment variables
-	if PYTHON_API_HOST == "" {
-		FailError(err, "Error finding PYTHON_API_HOST: %v\n")
+	// Check if the registry host is set
+	if REGISTRY_HOST == "" {
+		return nil, fmt.Errorf("%w: REGISTRY_HOST environment variable not set", ErrInvalidConfig)
 	}
 
-	// Send the POST request to the Python API
-	resp, err := http.Post("http://"+PYTHON_API_HOST+":5005/send", "application/json", bytes.NewBuffer(jsonData))
+	// Send the signed registration request to the registry
+	req, err := http.NewRequest(http.MethodPost, "http://"+REGISTRY_HOST+":3434/register", bytes.NewBuffer(updatedJsonData))
 	if err != nil {
-		Warn("send message error: %v", err)
+		return nil, fmt.Errorf("error building registration request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	SignRequest(req, service.ID, updatedJsonData)
 
+	client := NewHTTPClient(10 * time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error sending registration request: %v", ErrRegistryUnavailable, err)
+	}
 	defer resp.Body.Close()
 
-	// Check for success response
+	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		Warn("Failed to send message, received status code: %d\n", resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body) // Read the response body for more details
+		return nil, registryStatusError("failed to register service", resp.StatusCode, body)
 	}
+
+	var lease Lease
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		return nil, fmt.Errorf("failed to decode lease: %w", err)
+	}
+
+	return &lease, nil
 }
 
-func RegisterService(service *ServiceConfig) error {
-	// Marshal the updated service data
-	updatedJsonData, err := json.Marshal(service)
-	FailError(err, "error marshalling updated service data: %v")
+// DeregisterService removes the service from the registry, e.g. while shutting down.
+func DeregisterService(serviceID string) error {
+	if REGISTRY_HOST == "" {
+		return fmt.Errorf("%w: REGISTRY_HOST environment variable not set", ErrInvalidConfig)
+	}
 
-	// Check if the registry host is set
+	req, err := http.NewRequest(http.MethodDelete, "http://"+REGISTRY_HOST+":3434/service/"+serviceID, nil)
+	if err != nil {
+		return fmt.Errorf("error building deregistration request: %w", err)
+	}
+	SignRequest(req, serviceID, nil)
+
+	client := NewHTTPClient(5 * time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: error sending deregistration request: %v", ErrRegistryUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return registryStatusError("failed to deregister service", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// FetchConfig reads a backend configuration (e.g. "postgresql" or "minio") from the registry into target.
+// The registry only serves the backends the service ID is allowed to use.
+func FetchConfig(serviceID, configType string, target interface{}) error {
+	_, err := LoadConfigFromRegistry(serviceID, "backends", configType, target)
+	return err
+}
+
+// LoadConfigFromRegistry reads the latest version of namespace/key from the
+// registry's config store, decodes its value into target and returns the version.
+// Together with FetchConfig this lets a service boot with only REGISTRY_HOST set.
+func LoadConfigFromRegistry(serviceID, namespace, key string, target interface{}) (int64, error) {
 	if REGISTRY_HOST == "" {
-		return Err("REGISTRY_HOST environment variable not set")
+		return 0, fmt.Errorf("%w: REGISTRY_HOST environment variable not set", ErrInvalidConfig)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+REGISTRY_HOST+":3434/config/"+namespace+"/"+key, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build config request: %w", err)
 	}
+	SignRequest(req, serviceID, nil)
 
-	// Send the registration request to the registry
-	resp, err := http.Post("http://"+REGISTRY_HOST+":3434/register", "application/json", bytes.NewBuffer(updatedJsonData))
-	FailError(err, "error sending registration request: %v")
+	client := NewHTTPClient(5 * time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to fetch config %s/%s: %v", ErrRegistryUnavailable, namespace, key, err)
+	}
 	defer resp.Body.Close()
 
-	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body) // Read the response body for more details
-		return fmt.Errorf("failed to register service, received status code: %d, response: %s", resp.StatusCode, body)
+		body, _ := io.ReadAll(resp.Body)
+		return 0, registryStatusError(fmt.Sprintf("failed to fetch config %s/%s", namespace, key), resp.StatusCode, body)
 	}
 
-	return nil
+	var entry ConfigEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return 0, fmt.Errorf("failed to decode config %s/%s: %w", namespace, key, err)
+	}
+	if err := json.Unmarshal(entry.Value, target); err != nil {
+		return 0, fmt.Errorf("failed to decode config value %s/%s: %w", namespace, key, err)
+	}
+
+	return entry.Version, nil
 }
 
 // HealthHandler returns an HTTP handler function for the health check endpoint.
+// It always answers healthy; services that depend on other systems should
+// use Health so readiness reflects those dependencies.
 func HealthHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -114,37 +169,8 @@ func HealthHandler() http.HandlerFunc {
 	}
 }
 
+// ExternalIP returns the address this host advertises to the registry.
+// See AdvertiseAddress for how it is selected.
 func ExternalIP() (string, error) {
-	ifaces, err := net.Interfaces()
-	FailError(err, "Interfaces error: %v\n")
-
-	for _, iface := range ifaces {
-		if iface.Flags&net.FlagUp == 0 {
-			continue // interface down
-		}
-		if iface.Flags&net.FlagLoopback != 0 {
-			continue // loopback interface
-		}
-		addrs, err := iface.Addrs()
-		FailError(err, "")
-
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			if ip == nil || ip.IsLoopback() {
-				continue
-			}
-			ip = ip.To4()
-			if ip == nil {
-				continue // not an ipv4 address
-			}
-			return ip.String(), nil
-		}
-	}
-	return "", Err("No network connection found")
+	return AdvertiseAddress()
 }
@@ -5,14 +5,26 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+var dotEnvOnce sync.Once
+
+// loadDotEnv loads ../.env and .env into the environment once.
+// Variables already set in the environment are not overridden.
+func loadDotEnv() {
+	dotEnvOnce.Do(func() {
+		godotenv.Load("../.env")
+		godotenv.Load(".env")
+	})
+}
+
 // It returns the value if found, otherwise the provided default value.
 func GetEnv(key string, defaultValue string) string {
-	godotenv.Load("../.env")
-	godotenv.Load(".env")
+	loadDotEnv()
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
@@ -21,113 +33,173 @@ func GetEnv(key string, defaultValue string) string {
 
 // It returns the value if found, otherwise the provided default value.
 func GetEnvAsBool(key string, defaultValue bool) bool {
-	godotenv.Load("../.env")
-	godotenv.Load(".env")
+	loadDotEnv()
 	if value, exists := os.LookupEnv(key); exists {
-		return value == "true"
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			Warn("Invalid boolean %s=%q, using %v", key, value, defaultValue)
+			return defaultValue
+		}
+		return b
 	}
 	return defaultValue
 }
 
 // It returns the value if found, otherwise the provided default value.
 func GetEnvAsInt(key string, defaultValue int) int {
-	godotenv.Load("../.env")
-	godotenv.Load(".env")
+	loadDotEnv()
 	if value, exists := os.LookupEnv(key); exists {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			Warn("Invalid integer %s=%q, using %d", key, value, defaultValue)
+			return defaultValue
 		}
+		return intValue
 	}
 	return defaultValue
 }
 
 // Config holds the configuration for MinIO.
 type MinIOConfig struct {
-	Endpoint        string
-	AccessKeyID     string
-	SecretAccessKey string
-	UseSSL          bool
-	TemplatesBucket string // Add bucket name to config
-}
-
-// LoadConfig loads the configuration from environment variables.
-func LoadMinIOConfig() *MinIOConfig {
-	return &MinIOConfig{
-		Endpoint:        GetEnv("MINIO_ENDPOINT", "192.168.4.70:9000"),
-		AccessKeyID:     GetEnv("MINIO_ACCESS_KEY_ID", "admin"),
-		SecretAccessKey: GetEnv("MINIO_SECRET_ACCESS_KEY", "password"),
-		UseSSL:          GetEnvAsBool("MINIO_USE_SSL", false),
-		TemplatesBucket: GetEnv("MINIO_TEMPLATES_BUCKET", "templates"), // Load bucket name
-	}
+	Endpoint        string `env:"MINIO_ENDPOINT" default:"localhost:9000"`
+	AccessKeyID     string `env:"MINIO_ACCESS_KEY_ID" required:"true"`
+	SecretAccessKey string `env:"MINIO_SECRET_ACCESS_KEY" required:"true" secret:"true"`
+	UseSSL          bool   `env:"MINIO_USE_SSL" default:"false"`
+	TemplatesBucket string `env:"MINIO_TEMPLATES_BUCKET" default:"templates"`
+}
+
+func (*MinIOConfig) BackendName() string { return "minio" }
+func (c *MinIOConfig) String() string    { return Redact(c) }
+
+// LoadMinIOConfig loads the MinIO configuration, see LoadConfig.
+func LoadMinIOConfig() (*MinIOConfig, error) {
+	cfg := &MinIOConfig{}
+	return cfg, LoadConfig(cfg)
 }
 
 type ServiceConfig struct {
-	ID          string      `json:"id"`
-	Name        string      `json:"name"`
-	Address     string      `json:"address"`
-	Port        int         `json:"port"`
-	HealthCheck HealthCheck `json:"healthCheck"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Address     string            `json:"address"`
+	Port        int               `json:"port"`
+	HealthCheck HealthCheck       `json:"healthCheck"`
+	LeaseTTL    string            `json:"leaseTtl,omitempty"` // If set, the service sends heartbeats instead of being polled
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// ServiceEntry is a registered service together with its current status,
+// as returned by the registry's discovery endpoints.
+type ServiceEntry struct {
+	ServiceConfig
+	Status string `json:"status"`
 }
 
+// LoadServiceConfig parses a service.json. The id in the file names the
+// service; when SERVICE_INSTANCE is set it is suffixed with it, so several
+// hosts can run the same service.json without editing it while single
+// instances keep the plain id their ACL entry and secrets are keyed by.
+// The registry groups instances by Name.
 func LoadServiceConfig(jsonData []byte) (*ServiceConfig, error) {
 	var service ServiceConfig
 	err := json.Unmarshal(jsonData, &service)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshalling JSON data: %v", err)
 	}
+
+	if instance := GetEnv("SERVICE_INSTANCE", ""); instance != "" {
+		service.ID = service.ID + "-" + instance
+	}
 	return &service, nil
 }
 
+// InstanceName returns SERVICE_INSTANCE if set, otherwise the hostname.
+func InstanceName() string {
+	if instance := GetEnv("SERVICE_INSTANCE", ""); instance != "" {
+		return instance
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return hostname
+}
+
+// HealthCheck describes how the registry probes a service.
+// Type is one of http (default), tcp, amqp, redis, postgres or exec;
+// non-HTTP probes connect to the service address and port directly.
+// Interval and Timeout are Go duration strings such as "30s".
+// FailureThreshold and SuccessThreshold are the number of consecutive
+// failed or successful probes needed before the status flips; zero
+// means the registry default is used.
 type HealthCheck struct {
-	Endpoint string `json:"endpoint"`
-	Interval string `json:"interval"`
-	Timeout  string `json:"timeout"`
+	Type             string   `json:"type,omitempty"`
+	Endpoint         string   `json:"endpoint"`
+	Interval         string   `json:"interval"`
+	Timeout          string   `json:"timeout"`
+	FailureThreshold int      `json:"failureThreshold,omitempty"`
+	SuccessThreshold int      `json:"successThreshold,omitempty"`
+	ExpectedStatus   int      `json:"expectedStatus,omitempty"` // HTTP only; any 2xx when zero
+	ExpectedBody     string   `json:"expectedBody,omitempty"`   // HTTP only; substring of the response body
+	Command          []string `json:"command,omitempty"`        // exec only; run on the registry host
+}
+
+// ConfigEntry is one version of a value in the registry's config store.
+type ConfigEntry struct {
+	Namespace string          `json:"namespace"`
+	Key       string          `json:"key"`
+	Version   int64           `json:"version"`
+	Value     json.RawMessage `json:"value"`
+	UpdatedAt time.Time       `json:"updatedAt,omitempty"`
+	UpdatedBy string          `json:"updatedBy,omitempty"`
 }
 
 type PostgreSQLConfig struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	DBName   string
-}
-
-// LoadConfig loads the PostgreSQL database configuration from environment variables.
-func LoadPostgreSQLConfig() *PostgreSQLConfig {
-	return &PostgreSQLConfig{
-		Host:     GetEnv("DB_HOST", "192.168.4.61"),
-		Port:     GetEnv("DB_PORT", "5432"),
-		User:     GetEnv("DB_USER", "postgres"),
-		Password: GetEnv("DB_PASSWORD", "postgres"),
-		DBName:   GetEnv("DB_NAME", "postgres"),
-	}
+	Host     string `env:"DB_HOST" default:"localhost"`
+	Port     string `env:"DB_PORT" default:"5432"`
+	User     string `env:"DB_USER" default:"postgres"`
+	Password string `env:"DB_PASSWORD" required:"true" secret:"true"`
+	DBName   string `env:"DB_NAME" default:"postgres"`
 }
 
-// Config holds the configuration for RabbitMQ
+func (*PostgreSQLConfig) BackendName() string { return "postgresql" }
+func (c *PostgreSQLConfig) String() string    { return Redact(c) }
+
+// LoadPostgreSQLConfig loads the PostgreSQL database configuration, see LoadConfig.
+func LoadPostgreSQLConfig() (*PostgreSQLConfig, error) {
+	cfg := &PostgreSQLConfig{}
+	return cfg, LoadConfig(cfg)
+}
+
+// Config holds the configuration for RabbitMQ.
+// Host is the AMQP URL. Username and Password are not part of it; the
+// registry's AMQP probe reads them from the same variables.
 type RabbitMQConfig struct {
-	Host     string
-	Username string
-	Password string
+	Host     string `env:"RABBITMQ_URL" required:"true" secret:"true"`
+	Username string `env:"RABBITMQ_USERNAME" default:"guest"`
+	Password string `env:"RABBITMQ_PASSWORD" secret:"true"`
+	Prefetch int    `env:"RABBITMQ_PREFETCH" default:"1"` // unacked messages per consumer
 }
 
-// LoadConfig loads the configuration from environment variables
-func LoadRabbitMQConfig() *RabbitMQConfig {
-	return &RabbitMQConfig{
-		Host:     GetEnv("RABBITMQ_URL", "192.168.4.62"),
-		Username: GetEnv("RABBITMQ_USERNAME", "admin"),
-		Password: GetEnv("RABBITMQ_PASSWORD", "password"),
-	}
+func (*RabbitMQConfig) BackendName() string { return "rabbitmq" }
+func (c *RabbitMQConfig) String() string    { return Redact(c) }
+
+// LoadRabbitMQConfig loads the RabbitMQ configuration, see LoadConfig.
+func LoadRabbitMQConfig() (*RabbitMQConfig, error) {
+	cfg := &RabbitMQConfig{}
+	return cfg, LoadConfig(cfg)
 }
 
 type RedisConfig struct {
-	Host string
-	Port string
+	Host string `env:"REDIS_HOST" default:"localhost"`
+	Port string `env:"REDIS_PORT" default:"6379"`
 }
 
-// LoadConfig loads the Redis configuration from environment variables.
-func LoadRedisConfig() *RedisConfig {
-	return &RedisConfig{
-		Host: GetEnv("REDIS_HOST", "192.168.4.60"),
-		Port: GetEnv("REDIS_PORT", "6379"),
-	}
+func (*RedisConfig) BackendName() string { return "redis" }
+func (c *RedisConfig) String() string    { return Redact(c) }
+
+// LoadRedisConfig loads the Redis configuration, see LoadConfig.
+func LoadRedisConfig() (*RedisConfig, error) {
+	cfg := &RedisConfig{}
+	return cfg, LoadConfig(cfg)
 }
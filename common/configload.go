@@ -0,0 +1,275 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Typed configs are plain structs whose fields carry these tags:
+//
+//	env:"DB_HOST"      variable read from the environment and .env files;
+//	                   a variable set to "" counts as unset
+//	default:"5432"     value used when no source sets the field
+//	required:"true"    loading fails when the field is still empty
+//	secret:"true"      value is masked by Redact
+//
+// Sources are applied from lowest to highest priority: defaults, the
+// registry's config store, config files, .env files and finally the
+// process environment. Supported field kinds are string, bool, int and
+// time.Duration.
+
+// BackendConfig is implemented by configs the registry serves in its
+// "backends" namespace. The backend name also selects the section of a
+// config file, e.g. {"postgresql": {"host": "..."}}.
+type BackendConfig interface {
+	BackendName() string
+}
+
+// ConfigLoader fills typed configs from its sources.
+type ConfigLoader struct {
+	// ServiceID enables the registry source; it is the identity used to sign the request.
+	ServiceID string
+	// Files are JSON or YAML files, chosen by extension; later files win.
+	Files []string
+	// EnvFiles are dotenv files; earlier files win, as with godotenv.Load.
+	EnvFiles []string
+
+	envOnce sync.Once
+	envFile map[string]string
+}
+
+// DefaultConfigLoader reads ../.env, .env and the file named by CONFIG_FILE.
+// NewService sets its ServiceID so backend configs can come from the registry.
+var DefaultConfigLoader = &ConfigLoader{
+	EnvFiles: []string{"../.env", ".env"},
+}
+
+// LoadConfig fills target, a pointer to a typed config, using DefaultConfigLoader.
+func LoadConfig(target interface{}) error {
+	return DefaultConfigLoader.Load(target)
+}
+
+// ConfigError lists every problem found while loading a config.
+type ConfigError struct {
+	Config   string
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s configuration:\n  - %s", e.Config, strings.Join(e.Problems, "\n  - "))
+}
+
+func (e *ConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// Load fills target from all sources, then validates it. Problems are
+// collected and returned together as a *ConfigError.
+func (l *ConfigLoader) Load(target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: target must be a pointer to a struct, got %T", ErrInvalidConfig, target)
+	}
+	v = v.Elem()
+	report := &ConfigError{Config: v.Type().Name()}
+
+	// 1. Defaults
+	eachField(v, func(field reflect.StructField, value reflect.Value) {
+		if def, ok := field.Tag.Lookup("default"); ok {
+			if err := setField(value, def); err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s: invalid default %q: %v", field.Name, def, err))
+			}
+		}
+	})
+
+	// 2. Registry
+	backend, isBackend := target.(BackendConfig)
+	if isBackend && l.ServiceID != "" && REGISTRY_HOST != "" {
+		if _, err := LoadConfigFromRegistry(l.ServiceID, "backends", backend.BackendName(), target); err != nil && !errors.Is(err, ErrNotFound) {
+			Warn("Config %s not loaded from registry: %v", backend.BackendName(), err)
+		}
+	}
+
+	// 3. Config files
+	for _, path := range l.files() {
+		if err := loadConfigFile(path, target); err != nil {
+			report.Problems = append(report.Problems, err.Error())
+		}
+	}
+
+	// 4. .env files and 5. the environment
+	eachField(v, func(field reflect.StructField, value reflect.Value) {
+		name := field.Tag.Get("env")
+		if name == "" {
+			return
+		}
+		raw := os.Getenv(name)
+		if raw == "" {
+			raw = l.dotEnv()[name]
+		}
+		if raw == "" {
+			return
+		}
+		if err := setField(value, raw); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: invalid value %q: %v", name, raw, err))
+		}
+	})
+
+	// Validation
+	eachField(v, func(field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("required") == "true" && value.IsZero() {
+			name := field.Tag.Get("env")
+			if name == "" {
+				name = field.Name
+			}
+			report.Problems = append(report.Problems, name+" is required")
+		}
+	})
+
+	if len(report.Problems) > 0 {
+		return report
+	}
+	return nil
+}
+
+func (l *ConfigLoader) files() []string {
+	if len(l.Files) > 0 {
+		return l.Files
+	}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return []string{path}
+	}
+	return nil
+}
+
+// dotEnv reads the .env files once; later lookups use the cached values.
+func (l *ConfigLoader) dotEnv() map[string]string {
+	l.envOnce.Do(func() {
+		l.envFile = make(map[string]string)
+		for i := len(l.EnvFiles) - 1; i >= 0; i-- {
+			values, err := godotenv.Read(l.EnvFiles[i])
+			if err != nil {
+				continue // a missing .env file is normal
+			}
+			for k, v := range values {
+				l.envFile[k] = v
+			}
+		}
+	})
+	return l.envFile
+}
+
+// loadConfigFile decodes a JSON or YAML file into target. YAML is converted
+// to JSON first, so both formats use the same, case-insensitive, keys.
+func loadConfigFile(path string, target interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	default:
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	// Files may hold several backends, one section each
+	if backend, ok := target.(BackendConfig); ok {
+		section, found := doc[backend.BackendName()]
+		if !found {
+			return nil
+		}
+		values, ok := section.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("config file %s: section %s is not an object", path, backend.BackendName())
+		}
+		doc = values
+	}
+
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to convert config file %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+func eachField(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			fn(t.Field(i), v.Field(i))
+		}
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setField parses raw into a field of one of the supported kinds.
+func setField(value reflect.Value, raw string) error {
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", value.Type())
+	}
+	return nil
+}
+
+// Redact formats a typed config as Name=value pairs with secret fields
+// masked, so configs can be logged safely.
+func Redact(config interface{}) string {
+	v := reflect.Indirect(reflect.ValueOf(config))
+	if v.Kind() != reflect.Struct {
+		return fmt.Sprint(config)
+	}
+
+	var parts []string
+	eachField(v, func(field reflect.StructField, value reflect.Value) {
+		shown := fmt.Sprint(value.Interface())
+		if field.Tag.Get("secret") == "true" && !value.IsZero() {
+			shown = "******"
+		}
+		parts = append(parts, field.Name+"="+shown)
+	})
+	return "{" + strings.Join(parts, " ") + "}"
+}
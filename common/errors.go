@@ -0,0 +1,41 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinel errors returned (wrapped) by common and by the repository and
+// client packages. Check them with errors.Is to decide whether to retry,
+// degrade or give up.
+var (
+	// ErrRegistryUnavailable means the registry could not be reached or
+	// failed on its side; retrying later may succeed.
+	ErrRegistryUnavailable = errors.New("registry unavailable")
+	// ErrNotFound means the requested record, key or object does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized means the registry rejected the request's credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrInvalidConfig means a required setting is missing or malformed.
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrLeaseExpired is returned by RenewLease when the registry no longer knows the lease.
+	ErrLeaseExpired = errors.New("lease expired")
+)
+
+// registryStatusError maps an unexpected registry response status to one of
+// the sentinel errors, keeping the status and body for the message.
+func registryStatusError(action string, status int, body []byte) error {
+	var kind error
+	switch {
+	case status == http.StatusNotFound:
+		kind = ErrNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		kind = ErrUnauthorized
+	case status >= 500:
+		kind = ErrRegistryUnavailable
+	default:
+		return fmt.Errorf("%s, received status code: %d, response: %s", action, status, body)
+	}
+	return fmt.Errorf("%s: %w (status code: %d, response: %s)", action, kind, status, body)
+}
@@ -0,0 +1,151 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CheckFunc reports whether a dependency is usable; nil means healthy.
+type CheckFunc func(ctx context.Context) error
+
+// Health collects the dependency checks of a service and serves them as
+// a liveness and a readiness endpoint. Liveness only tells that the process
+// answers; readiness runs every registered check.
+//
+//	health.Register("postgres", func(ctx context.Context) error {
+//		return postgresql.Ping(ctx, postgresClient)
+//	})
+type Health struct {
+	// Timeout bounds each check.
+	Timeout time.Duration
+
+	mu     sync.RWMutex
+	checks map[string]CheckFunc
+}
+
+// CheckResult is the outcome of one check in a HealthReport.
+type CheckResult struct {
+	Status   string `json:"status"` // "up" or "down"
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthReport is the JSON body of the readiness endpoint.
+type HealthReport struct {
+	Status string                 `json:"status"` // "ready" or "not ready"
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Ready reports whether every check passed.
+func (r HealthReport) Ready() bool {
+	return r.Status == "ready"
+}
+
+// NewHealth creates an empty set of checks with a 2s timeout per check.
+func NewHealth() *Health {
+	return &Health{
+		Timeout: 2 * time.Second,
+		checks:  make(map[string]CheckFunc),
+	}
+}
+
+// Register adds or replaces the named check.
+func (h *Health) Register(name string, check CheckFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// Check runs all checks concurrently and collects their results.
+func (h *Health) Check(ctx context.Context) HealthReport {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]CheckFunc, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.RUnlock()
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := runCheck(checkCtx, checks[i])
+			results[i] = CheckResult{Status: "up", Duration: time.Since(start).Round(time.Microsecond).String()}
+			if err != nil {
+				results[i].Status = "down"
+				results[i].Error = err.Error()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: "ready", Checks: make(map[string]CheckResult, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != "up" {
+			report.Status = "not ready"
+		}
+	}
+	return report
+}
+
+// runCheck runs a check but gives up when ctx ends, so a hanging client
+// cannot block the readiness endpoint.
+func runCheck(ctx context.Context, check CheckFunc) error {
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LiveHandler serves the liveness endpoint; it does not run any check.
+func (h *Health) LiveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"alive"}`))
+	}
+}
+
+// ReadyHandler serves the readiness endpoint: 200 when every check passes,
+// 503 otherwise, with the result of each check in the body.
+func (h *Health) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := h.Check(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Ready() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// Handle mounts /health/live and /health/ready on mux. /health answers
+// like /health/ready, so registry probes of the old path see real readiness.
+func (h *Health) Handle(mux *http.ServeMux) {
+	mux.HandleFunc("/health/live", h.LiveHandler())
+	mux.HandleFunc("/health/ready", h.ReadyHandler())
+	mux.HandleFunc("/health", h.ReadyHandler())
+}
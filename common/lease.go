@@ -0,0 +1,89 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Lease is the registration lease handed out by the registry.
+// A lease without a TTL never expires and needs no heartbeat.
+type Lease struct {
+	ID        string `json:"leaseId"`
+	ServiceID string `json:"serviceId"`
+	TTL       string `json:"ttl,omitempty"`
+}
+
+// RenewLease sends a single heartbeat for the lease.
+func RenewLease(lease *Lease) error {
+	if REGISTRY_HOST == "" {
+		return fmt.Errorf("%w: REGISTRY_HOST environment variable not set", ErrInvalidConfig)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, "http://"+REGISTRY_HOST+":3434/lease/"+lease.ID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build heartbeat request: %w", err)
+	}
+	SignRequest(req, lease.ServiceID, nil)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: failed to send heartbeat: %v", ErrRegistryUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrLeaseExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		return registryStatusError("failed to renew lease", resp.StatusCode, nil)
+	}
+	return nil
+}
+
+// Heartbeat keeps the lease of a service alive until ctx is cancelled.
+// Heartbeats are sent at a third of the TTL; if the lease has lapsed the
+// service is registered again and the new lease is used from then on.
+func Heartbeat(ctx context.Context, service *ServiceConfig, lease *Lease) {
+	if lease == nil || lease.TTL == "" {
+		return
+	}
+
+	ttl, err := time.ParseDuration(lease.TTL)
+	if err != nil || ttl <= 0 {
+		Warn("Invalid lease TTL %q, heartbeat disabled", lease.TTL)
+		return
+	}
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := RenewLease(lease)
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, ErrLeaseExpired) {
+			Warn("Heartbeat failed for service %s: %v", service.ID, err)
+			continue
+		}
+
+		Warn("Lease for service %s expired, registering again", service.ID)
+		newLease, err := RegisterService(service)
+		if err != nil {
+			Warn("Failed to register service %s: %v", service.ID, err)
+			continue
+		}
+		lease = newLease
+	}
+}
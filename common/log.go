@@ -0,0 +1,211 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Field names shared by every service, so logger-s and Elasticsearch can
+// index them consistently.
+const (
+	FieldService    = "service"
+	FieldRequestID  = "request_id"
+	FieldMachineID  = "machine_id"
+	FieldTemplateID = "template_id"
+	FieldUserID     = "user_id"
+	FieldTraceID    = "trace_id"
+	FieldSpanID     = "span_id"
+)
+
+// kindKey tags records written by the Head/Out/Ok helpers so the console
+// handler can keep their colors; JSON output carries it as a plain field.
+const kindKey = "kind"
+
+// Log is the structured logger behind Info, Warn, Err and friends.
+// Use InitLogger to name the service and pick the output format.
+var Log = slog.New(newConsoleHandler(os.Stdout, slog.LevelInfo))
+
+// InitLogger configures Log for a service. LOG_FORMAT selects "console"
+// (default, colored text) or "json"; LOG_LEVEL is debug, info, warn or error.
+func InitLogger(service string) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(GetEnv("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(GetEnv("LOG_FORMAT", "console")) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	default:
+		handler = newConsoleHandler(os.Stdout, level)
+	}
+
+	Log = slog.New(handler).With(FieldService, service)
+	slog.SetDefault(Log)
+}
+
+type logFieldsKey struct{}
+
+// WithLogFields returns a context carrying extra log fields, e.g.
+// WithLogFields(ctx, FieldMachineID, id, FieldUserID, userID).
+func WithLogFields(ctx context.Context, args ...any) context.Context {
+	fields, _ := ctx.Value(logFieldsKey{}).([]any)
+	merged := append(append([]any{}, fields...), args...)
+	return context.WithValue(ctx, logFieldsKey{}, merged)
+}
+
+// RequestID returns the request ID stored in ctx by WithLogFields, if any.
+func RequestID(ctx context.Context) string {
+	fields, _ := ctx.Value(logFieldsKey{}).([]any)
+	for i := len(fields) - 2; i >= 0; i -= 2 {
+		if fields[i] == FieldRequestID {
+			id, _ := fields[i+1].(string)
+			return id
+		}
+	}
+	return ""
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Logger returns Log with the fields stored in ctx by WithLogFields and,
+// when ctx carries a span, the trace and span IDs.
+func Logger(ctx context.Context) *slog.Logger {
+	fields, _ := ctx.Value(logFieldsKey{}).([]any)
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		fields = append(append([]any{}, fields...), FieldTraceID, span.TraceID().String(), FieldSpanID, span.SpanID().String())
+	}
+	if len(fields) > 0 {
+		return Log.With(fields...)
+	}
+	return Log
+}
+
+func logf(level slog.Level, kind, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if kind != "" {
+		Log.Log(context.Background(), level, msg, kindKey, kind)
+		return
+	}
+	Log.Log(context.Background(), level, msg)
+}
+
+func logFunc(level slog.Level, kind string) func(format string, a ...interface{}) {
+	return func(format string, a ...interface{}) {
+		logf(level, kind, format, a...)
+	}
+}
+
+// errorLogFunc logs like logFunc and also returns the message as an error,
+// so call sites can write `return common.Err(...)`.
+func errorLogFunc(level slog.Level, kind string) func(format string, a ...interface{}) error {
+	return func(format string, a ...interface{}) error {
+		err := fmt.Errorf(format, a...)
+		logf(level, kind, "%s", err.Error())
+		return err
+	}
+}
+
+func fatalLogFunc(level slog.Level, kind string) func(format string, a ...interface{}) {
+	return func(format string, a ...interface{}) {
+		logf(level, kind, format, a...)
+		os.Exit(1) // Terminate the program
+	}
+}
+
+// consoleHandler writes colored, human-readable lines: the message
+// followed by its fields as key=value pairs.
+type consoleHandler struct {
+	w      io.Writer
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+	mu     *sync.Mutex
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler) *consoleHandler {
+	return &consoleHandler{w: w, level: level, mu: &sync.Mutex{}}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	kind := ""
+	var fields strings.Builder
+	appendAttr := func(a slog.Attr) {
+		if a.Key == kindKey {
+			kind = a.Value.String()
+			return
+		}
+		if a.Key == FieldService {
+			return // one service per process; no need to repeat it on every line
+		}
+		fmt.Fprintf(&fields, " %s=%v", a.Key, a.Value)
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+		return true
+	})
+
+	var c *color.Color
+	switch {
+	case r.Level >= slog.LevelError:
+		c = color.New(color.FgHiRed).Add(color.Bold)
+	case r.Level >= slog.LevelWarn:
+		c = color.New(color.FgHiYellow).Add(color.Bold)
+	case kind == "head":
+		c = color.New(color.FgHiMagenta).Add(color.Bold).Add(color.Underline)
+	case kind == "out":
+		c = color.New(color.FgHiWhite)
+	case kind == "ok":
+		c = color.New(color.FgHiGreen)
+	case r.Level < slog.LevelInfo:
+		c = color.New(color.FgHiBlack)
+	default:
+		c = color.New(color.FgCyan)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r.Level >= slog.LevelError {
+		fmt.Fprintln(h.w)
+	}
+	_, err := c.Fprintln(h.w, r.Message+fields.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		clone.attrs = append(clone.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
@@ -0,0 +1,294 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier delivers a human-readable message to some channel.
+type Notifier interface {
+	Notify(ctx context.Context, message string) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface.
+type NotifierFunc func(ctx context.Context, message string) error
+
+func (f NotifierFunc) Notify(ctx context.Context, message string) error {
+	return f(ctx, message)
+}
+
+var notifyClient = NewHTTPClient(10 * time.Second)
+
+// postJSON posts a JSON payload and treats any non-2xx status as an error.
+func postJSON(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("received status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// TelegramNotifier sends messages through the Telegram Bot API directly.
+// Messages go out as plain text: they carry service IDs and errors, whose
+// _, * and [ would break Markdown parsing and get the message rejected.
+type TelegramNotifier struct {
+	Token  string
+	ChatID string
+}
+
+func (t *TelegramNotifier) Notify(ctx context.Context, message string) error {
+	return postJSON(ctx, "https://api.telegram.org/bot"+t.Token+"/sendMessage", map[string]string{
+		"chat_id": t.ChatID,
+		"text":    message,
+	})
+}
+
+// WebhookNotifier posts {"message": ...} to a URL. This is also the format
+// of the Python Telegram bridge (common/telegram_bot.py).
+type WebhookNotifier struct {
+	URL string
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, message string) error {
+	return postJSON(ctx, n.URL, map[string]string{"message": message})
+}
+
+// SlackNotifier posts to a Slack-compatible incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, message string) error {
+	return postJSON(ctx, n.WebhookURL, map[string]string{"text": message})
+}
+
+// StdoutNotifier prints messages instead of sending them; useful locally.
+type StdoutNotifier struct{}
+
+func (StdoutNotifier) Notify(ctx context.Context, message string) error {
+	Out("[notify] %s", message)
+	return nil
+}
+
+// NopNotifier discards every message.
+type NopNotifier struct{}
+
+func (NopNotifier) Notify(ctx context.Context, message string) error { return nil }
+
+// MultiNotifier sends every message to all of its notifiers.
+type MultiNotifier []Notifier
+
+func (m MultiNotifier) Notify(ctx context.Context, message string) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ctx, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AsyncNotifier queues messages in a buffer and delivers them from a single
+// worker, retrying failures with backoff and spacing sends by MinInterval.
+// A full buffer drops messages, so callers never block on delivery.
+type AsyncNotifier struct {
+	next        Notifier
+	queue       chan string
+	MaxRetries  int
+	MinInterval time.Duration
+
+	done   chan struct{}
+	mu     sync.Mutex // guards closed and sends on queue
+	closed bool
+}
+
+// NewAsyncNotifier starts the delivery worker for next.
+func NewAsyncNotifier(next Notifier, bufferSize int) *AsyncNotifier {
+	a := &AsyncNotifier{
+		next:        next,
+		queue:       make(chan string, bufferSize),
+		MaxRetries:  3,
+		MinInterval: time.Second,
+		done:        make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Notify queues the message and returns immediately.
+func (a *AsyncNotifier) Notify(ctx context.Context, message string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return errors.New("notifier closed")
+	}
+
+	select {
+	case a.queue <- message:
+		return nil
+	default:
+		return errors.New("notification queue full, message dropped")
+	}
+}
+
+// Close stops accepting messages and waits for the queue to drain or ctx to end.
+func (a *AsyncNotifier) Close(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *AsyncNotifier) run() {
+	defer close(a.done)
+
+	var last time.Time
+	for message := range a.queue {
+		if wait := a.MinInterval - time.Since(last); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := a.next.Notify(ctx, message)
+			cancel()
+			if err == nil {
+				break
+			}
+			if attempt >= a.MaxRetries {
+				Warn("Failed to send notification after %d attempts: %v", attempt+1, err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		last = time.Now()
+	}
+}
+
+var (
+	defaultNotifier Notifier
+	notifierOnce    sync.Once
+	notifierMu      sync.RWMutex
+)
+
+// SetNotifier replaces the notifier used by Notify.
+func SetNotifier(n Notifier) {
+	notifierOnce.Do(func() {})
+	notifierMu.Lock()
+	defaultNotifier = n
+	notifierMu.Unlock()
+}
+
+// NewNotifierFromEnv builds the notifier selected by NOTIFIER, a comma
+// separated list of telegram, webhook, slack, stdout or none.
+// Without NOTIFIER, Telegram is used when TELEGRAM_TOKEN and CHAT_ID are
+// set, then the Python bridge at PYTHON_API_HOST, then stdout.
+func NewNotifierFromEnv() Notifier {
+	kinds := GetEnv("NOTIFIER", "")
+	if kinds == "" {
+		switch {
+		case TELEGRAM_TOKEN != "" && CHAT_ID != "":
+			kinds = "telegram"
+		case PYTHON_API_HOST != "":
+			kinds = "bridge"
+		default:
+			kinds = "stdout"
+		}
+	}
+
+	var notifiers MultiNotifier
+	for _, kind := range strings.Split(kinds, ",") {
+		switch strings.TrimSpace(kind) {
+		case "telegram":
+			notifiers = append(notifiers, &TelegramNotifier{
+				Token:  GetEnv("TELEGRAM_TOKEN", TELEGRAM_TOKEN),
+				ChatID: GetEnv("CHAT_ID", CHAT_ID),
+			})
+		case "bridge":
+			host := strings.TrimPrefix(strings.TrimPrefix(PYTHON_API_HOST, "http://"), "https://")
+			notifiers = append(notifiers, &WebhookNotifier{URL: "http://" + host + ":5005/send"})
+		case "webhook":
+			notifiers = append(notifiers, &WebhookNotifier{URL: GetEnv("NOTIFY_WEBHOOK_URL", "")})
+		case "slack":
+			notifiers = append(notifiers, &SlackNotifier{WebhookURL: GetEnv("SLACK_WEBHOOK_URL", "")})
+		case "stdout":
+			notifiers = append(notifiers, StdoutNotifier{})
+		case "none", "":
+		default:
+			Warn("Unknown notifier %q ignored", kind)
+		}
+	}
+
+	if len(notifiers) == 0 {
+		return NopNotifier{}
+	}
+	if len(notifiers) == 1 {
+		return NewAsyncNotifier(notifiers[0], 100)
+	}
+	return NewAsyncNotifier(notifiers, 100)
+}
+
+// CloseNotifier delivers the messages still queued in the default notifier
+// and stops it, waiting at most until ctx ends. Later messages are dropped.
+func CloseNotifier(ctx context.Context) error {
+	notifierMu.RLock()
+	n := defaultNotifier
+	notifierMu.RUnlock()
+
+	if closer, ok := n.(interface{ Close(context.Context) error }); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
+
+// Notify sends a message through the default notifier without blocking.
+// The default is built from the environment on first use.
+func Notify(message string) {
+	notifierOnce.Do(func() {
+		notifierMu.Lock()
+		defaultNotifier = NewNotifierFromEnv()
+		notifierMu.Unlock()
+	})
+
+	notifierMu.RLock()
+	n := defaultNotifier
+	notifierMu.RUnlock()
+
+	if err := n.Notify(context.Background(), message); err != nil {
+		Warn("Notification not sent: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Strategy picks one instance out of the healthy instances of a service.
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	Random
+)
+
+// Resolver looks up healthy service instances in the registry and balances
+// between them on the client side. Lookups are cached for TTL. Each name
+// is refreshed by one caller at a time, outside the lock, and when a
+// refresh fails the expired instances are used until the next one works.
+type Resolver struct {
+	Strategy Strategy
+	TTL      time.Duration
+
+	mu       sync.Mutex
+	cache    map[string]*resolverEntry
+	fetching map[string]*resolverFetch
+}
+
+type resolverEntry struct {
+	instances []ServiceEntry
+	fetchedAt time.Time
+	next      int
+}
+
+// resolverFetch is a registry lookup in progress; entry and err are set
+// before done is closed.
+type resolverFetch struct {
+	done  chan struct{}
+	entry *resolverEntry
+	err   error
+}
+
+// DefaultResolver is used by Resolve.
+var DefaultResolver = NewResolver(RoundRobin, 10*time.Second)
+
+// NewResolver creates a resolver with the given strategy and cache TTL.
+func NewResolver(strategy Strategy, ttl time.Duration) *Resolver {
+	return &Resolver{
+		Strategy: strategy,
+		TTL:      ttl,
+		cache:    make(map[string]*resolverEntry),
+		fetching: make(map[string]*resolverFetch),
+	}
+}
+
+// Resolve returns a healthy instance of the named service using DefaultResolver.
+func Resolve(name string) (*ServiceEntry, error) {
+	return DefaultResolver.Resolve(name)
+}
+
+// Resolve returns a healthy instance of the named service.
+func (r *Resolver) Resolve(name string) (*ServiceEntry, error) {
+	entry, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(entry.instances) == 0 {
+		return nil, fmt.Errorf("%w: no healthy instance of service %s", ErrNotFound, name)
+	}
+
+	var picked ServiceEntry
+	switch r.Strategy {
+	case Random:
+		picked = entry.instances[rand.Intn(len(entry.instances))]
+	default:
+		picked = entry.instances[entry.next%len(entry.instances)]
+		entry.next++
+	}
+
+	return &picked, nil
+}
+
+// lookup returns the cached instances of a service, refreshing them once
+// they are older than TTL.
+func (r *Resolver) lookup(name string) (*resolverEntry, error) {
+	r.mu.Lock()
+	cached := r.cache[name]
+	if cached != nil && time.Since(cached.fetchedAt) <= r.TTL {
+		r.mu.Unlock()
+		return cached, nil
+	}
+	fetch, running := r.fetching[name]
+	if !running {
+		fetch = &resolverFetch{done: make(chan struct{})}
+		r.fetching[name] = fetch
+	}
+	r.mu.Unlock()
+
+	if running {
+		<-fetch.done
+	} else {
+		instances, err := fetchHealthyInstances(name)
+
+		r.mu.Lock()
+		if err == nil {
+			fetch.entry = &resolverEntry{instances: instances, fetchedAt: time.Now()}
+			r.cache[name] = fetch.entry
+		}
+		fetch.err = err
+		delete(r.fetching, name)
+		r.mu.Unlock()
+		close(fetch.done)
+	}
+
+	if fetch.err == nil {
+		return fetch.entry, nil
+	}
+	if cached != nil {
+		Warn("Failed to refresh instances of %s, using cached ones: %v", name, fetch.err)
+		return cached, nil
+	}
+	return nil, fetch.err
+}
+
+// Invalidate drops the cached instances of a service, e.g. after a failed call.
+func (r *Resolver) Invalidate(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, name)
+}
+
+// fetchHealthyInstances asks the registry for the healthy instances of a service.
+func fetchHealthyInstances(name string) ([]ServiceEntry, error) {
+	if REGISTRY_HOST == "" {
+		return nil, fmt.Errorf("%w: REGISTRY_HOST environment variable not set", ErrInvalidConfig)
+	}
+
+	client := NewHTTPClient(5 * time.Second)
+	resp, err := client.Get("http://" + REGISTRY_HOST + ":3434/instances/" + url.PathEscape(name) + "?status=healthy")
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to query registry: %v", ErrRegistryUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, registryStatusError("failed to resolve service "+name, resp.StatusCode, nil)
+	}
+
+	var instances []ServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&instances); err != nil {
+		return nil, fmt.Errorf("failed to decode instances: %w", err)
+	}
+
+	return instances, nil
+}
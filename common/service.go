@@ -0,0 +1,327 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Hook is a lifecycle callback run by Service.
+type Hook func(ctx context.Context) error
+
+// Service is the runtime shared by the microservices. It loads the
+// environment and service.json, serves /health/live and /health/ready, registers with
+// the registry (retrying with backoff), runs lifecycle hooks and, on SIGINT
+// or SIGTERM, drains its workers before shutting down.
+//
+//	svc, err := common.NewService("service.json")
+//	common.FailError(err, "Failed to start service: %v", err)
+//	svc.OnInit(initClients)
+//	svc.OnShutdown(closeClients)
+//	svc.Go("consumer", consumeMessages)
+//	if err := svc.Run(); err != nil {
+//		common.Fatal("Service failed: %v", err)
+//	}
+type Service struct {
+	Config *ServiceConfig
+	// Mux is served on Config.Port next to the health endpoints.
+	Mux *http.ServeMux
+	// Health holds the dependency checks behind /health/ready.
+	Health *Health
+	// DrainTimeout bounds how long shutdown waits for workers and hooks.
+	DrainTimeout time.Duration
+
+	initHooks     []Hook
+	shutdownHooks []Hook
+
+	mu      sync.Mutex
+	started bool
+	pending []func()
+	workers sync.WaitGroup
+
+	ready      atomic.Bool
+	registered atomic.Bool
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   context.CancelFunc
+}
+
+// NewService loads ../.env and .env, sets the common globals from the
+// environment, reads the service configuration from configFile and names
+// the logger after the service.
+func NewService(configFile string) (*Service, error) {
+	loadDotEnv()
+
+	PYTHON_API_HOST = GetEnv("PYTHON_API_HOST", "")
+	TELEGRAM_TOKEN = GetEnv("TELEGRAM_TOKEN", "")
+	CHAT_ID = GetEnv("CHAT_ID", "")
+	REGISTRY_HOST = GetEnv("REGISTRY_HOST", "")
+	if REGISTRY_HOST == "" {
+		REGISTRY_HOST = DefaultRegistryHost
+	}
+	REGISTRY_SECRET = GetEnv("REGISTRY_SECRET", "")
+
+	serviceData, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read service configuration: %v", ErrInvalidConfig, err)
+	}
+	config, err := LoadServiceConfig(serviceData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to load service configuration: %v", ErrInvalidConfig, err)
+	}
+	InitLogger(config.ID)
+	DefaultConfigLoader.ServiceID = config.ID
+
+	shutdownTracing, err := InitTracing(config.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancelCause(signalCtx)
+
+	s := &Service{
+		Config:       config,
+		Mux:          http.NewServeMux(),
+		Health:       NewHealth(),
+		DrainTimeout: 30 * time.Second,
+		ctx:          ctx,
+		cancel:       cancel,
+		stop:         stop,
+		// Registered first so it runs last and flushes the spans of the other hooks
+		shutdownHooks: []Hook{shutdownTracing},
+	}
+	s.Health.Register("lifecycle", func(ctx context.Context) error {
+		switch {
+		case s.ctx.Err() != nil:
+			return errors.New("shutting down")
+		case !s.Ready():
+			return errors.New("starting")
+		}
+		return nil
+	})
+	return s, nil
+}
+
+// Context is cancelled when the service starts shutting down.
+func (s *Service) Context() context.Context {
+	return s.ctx
+}
+
+// OnInit adds a hook run, in order, before the service registers.
+// A failing init hook aborts Run.
+func (s *Service) OnInit(hook Hook) {
+	s.initHooks = append(s.initHooks, hook)
+}
+
+// OnShutdown adds a hook run after the workers have drained.
+// Shutdown hooks run in reverse order, like deferred calls.
+func (s *Service) OnShutdown(hook Hook) {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+// Go runs a worker, typically a message consumer, until the service context
+// is cancelled. Workers added before Run start once the init hooks have
+// succeeded. Shutdown waits for all workers to return, so a worker should
+// stop taking new work when ctx ends and return once in-flight work is done.
+// A worker failing with an error stops the service.
+func (s *Service) Go(name string, worker func(ctx context.Context) error) {
+	s.workers.Add(1)
+	start := func() {
+		go func() {
+			defer s.workers.Done()
+			if err := worker(s.ctx); err != nil && !errors.Is(err, context.Canceled) {
+				s.Shutdown(fmt.Errorf("worker %s failed: %w", name, err))
+			}
+		}()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		start()
+		return
+	}
+	s.pending = append(s.pending, start)
+}
+
+// startWorkers starts the workers queued by Go before Run.
+func (s *Service) startWorkers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
+	for _, start := range s.pending {
+		start()
+	}
+	s.pending = nil
+}
+
+// SetReady marks the service as ready or not ready to take traffic.
+// Run marks it ready once the init hooks have succeeded and not ready
+// while draining; /health/ready also requires every dependency check to pass.
+func (s *Service) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// Ready reports whether the service has started and is not draining.
+func (s *Service) Ready() bool {
+	return s.ready.Load()
+}
+
+// Registered reports whether the service holds a registry lease.
+func (s *Service) Registered() bool {
+	return s.registered.Load()
+}
+
+// Shutdown starts a graceful shutdown; cause is returned by Run when not nil.
+func (s *Service) Shutdown(cause error) {
+	s.cancel(cause)
+}
+
+// Run starts the service and blocks until it has shut down. It returns the
+// cause of the shutdown, or nil when the service was stopped by a signal.
+func (s *Service) Run() error {
+	if ip, err := AdvertiseAddress(); err == nil {
+		s.Config.Address = ip
+	} else {
+		Warn("Failed to resolve advertise address, using %q: %v", s.Config.Address, err)
+	}
+
+	server := s.serveHTTP()
+
+	for _, hook := range s.initHooks {
+		if err := hook(s.ctx); err != nil {
+			s.Shutdown(fmt.Errorf("init failed: %w", err))
+			break
+		}
+	}
+
+	if s.ctx.Err() == nil {
+		s.startWorkers()
+		s.SetReady(true)
+		go s.register()
+
+		Head("Starting %s...", s.Config.Name)
+		SendMessageToTelegram(fmt.Sprintf("%s ::: Service starting...", strings.ToUpper(s.Config.Name)))
+	}
+
+	<-s.ctx.Done()
+	s.stop()
+	return s.drain(server)
+}
+
+// serveHTTP starts the health and readiness server on the service port.
+func (s *Service) serveHTTP() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/", s.Mux)
+	s.Health.Handle(mux)
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", s.Config.Port), Handler: TraceHandler(mux, s.Config.Name)}
+	go func() {
+		Info("Starting %v on port %d", s.Config.Name, s.Config.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.Shutdown(fmt.Errorf("server failed: %w", err))
+		}
+	}()
+	return server
+}
+
+// register registers the service, retrying with exponential backoff while the
+// registry is unavailable, then keeps the lease alive until shutdown.
+// Errors that a retry cannot fix stop the service.
+func (s *Service) register() {
+	backoff := time.Second
+	for {
+		lease, err := RegisterService(s.Config)
+		if err == nil {
+			s.registered.Store(true)
+			SendMessageToTelegram(fmt.Sprintf("%s ::: Service registered successfully!", s.Config.Name))
+			Heartbeat(s.ctx, s.Config, lease)
+			return
+		}
+		if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrInvalidConfig) {
+			s.Shutdown(fmt.Errorf("failed to register service: %w", err))
+			return
+		}
+
+		Warn("Failed to register service, retrying in %v: %v", backoff, err)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// drain stops taking traffic, deregisters, waits for the workers, runs the
+// shutdown hooks and flushes pending notifications, all within DrainTimeout.
+func (s *Service) drain(server *http.Server) error {
+	cause := context.Cause(s.ctx)
+	if errors.Is(cause, context.Canceled) {
+		cause = nil // stopped by a signal
+	}
+	if cause != nil {
+		Err("%s stopping: %v", s.Config.Name, cause)
+	} else {
+		Info("%s stopping...", s.Config.Name)
+	}
+	SendMessageToTelegram(fmt.Sprintf("%s ::: Service stopping...", strings.ToUpper(s.Config.Name)))
+
+	s.SetReady(false)
+	if s.registered.Load() {
+		if err := DeregisterService(s.Config.ID); err != nil {
+			Warn("Failed to deregister service: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+	defer cancel()
+
+	// Workers that never started are released so Wait does not block on them
+	s.mu.Lock()
+	for range s.pending {
+		s.workers.Done()
+	}
+	s.pending = nil
+	s.started = true
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		Warn("Workers did not drain within %v", s.DrainTimeout)
+	}
+
+	for i := len(s.shutdownHooks) - 1; i >= 0; i-- {
+		if err := s.shutdownHooks[i](ctx); err != nil {
+			Err("Shutdown hook failed: %v", err)
+		}
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		Warn("Failed to stop HTTP server: %v", err)
+	}
+
+	Ok("%s stopped", s.Config.Name)
+
+	// Last, so the stop messages above still go out
+	if err := CloseNotifier(ctx); err != nil {
+		Warn("Pending notifications not sent: %v", err)
+	}
+	return cause
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of spans started through Tracer.
+const tracerName = "github.com/nesiler/cestx"
+
+// Trace context is always propagated (W3C traceparent and baggage), so a
+// service without an exporter still links the services around it.
+// OTEL_TRACES_EXPORTER selects where spans go:
+//
+//	otlp    OTLP over HTTP; the endpoint comes from OTEL_EXPORTER_OTLP_ENDPOINT
+//	        (default http://localhost:4318) and the other standard OTEL_* variables
+//	stdout  pretty-printed JSON, for local runs
+//	none    spans are not recorded (default)
+//
+// OTEL_TRACES_SAMPLER_ARG is the sampling ratio for new traces, 1 by default.
+func init() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// InitTracing installs the global tracer provider for a service. The
+// returned function flushes pending spans and must be called on shutdown.
+func InitTracing(service string) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch kind := strings.ToLower(GetEnv("OTEL_TRACES_EXPORTER", "none")); kind {
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown OTEL_TRACES_EXPORTER %q", ErrInvalidConfig, kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", GetEnv("OTEL_TRACES_EXPORTER", ""), err)
+	}
+
+	ratio := 1.0
+	if arg := GetEnv("OTEL_TRACES_SAMPLER_ARG", ""); arg != "" {
+		if _, err := fmt.Sscanf(arg, "%g", &ratio); err != nil {
+			return nil, fmt.Errorf("%w: invalid OTEL_TRACES_SAMPLER_ARG %q", ErrInvalidConfig, arg)
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(tracingResource(service)),
+	)
+	otel.SetTracerProvider(provider)
+
+	Info("Tracing enabled, exporting spans to %s", GetEnv("OTEL_TRACES_EXPORTER", ""))
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer used for spans started by the services.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// StartSpan starts a span as a child of the span in ctx, if any.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// RequestIDHeader carries the request ID between services.
+const RequestIDHeader = "X-Request-ID"
+
+// TraceHandler wraps an HTTP handler so every request gets a server span
+// continuing the caller's trace, and a request ID for Logger. The ID is
+// taken from the X-Request-ID header, or generated, and echoed in the
+// response. Health endpoints are not traced.
+func TraceHandler(handler http.Handler, operation string) http.Handler {
+	return otelhttp.NewHandler(requestIDHandler(handler), operation,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !strings.HasPrefix(r.URL.Path, "/health") && r.URL.Path != "/metrics"
+		}),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+func requestIDHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		handler.ServeHTTP(w, r.WithContext(WithLogFields(r.Context(), FieldRequestID, id)))
+	})
+}
+
+// NewHTTPClient returns an HTTP client whose requests carry the trace
+// context of the request context and are recorded as client spans.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+}
+
+func tracingResource(service string) *resource.Resource {
+	return resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(service),
+		semconv.ServiceInstanceID(InstanceName()),
+	)
+}
@@ -51,7 +51,7 @@ func Deploy(serviceName string) error {
 	}
 
 	common.Out("Deploying service: %s", serviceName)
-	common.SendMessageToTelegram("**DEPLOYER** ::: Deploying service: " + serviceName)
+	common.SendMessageToTelegram("DEPLOYER ::: Deploying service: " + serviceName)
 
 	playbook := config.AnsiblePath + "/update.yaml"
 
@@ -88,7 +88,7 @@ func handleSSHKeysAndServiceChecks() {
 		if !checkServiceExists(host.Name) {
 			common.Warn("Service or Repo does not exist for host %s\n", host.Name)
 			common.Info("Starting setup process for: %s\n", host.Name)
-			common.SendMessageToTelegram("**DEPLOYER** ::: Starting setup process for: " + host.Name)
+			common.SendMessageToTelegram("DEPLOYER ::: Starting setup process for: " + host.Name)
 
 			err := runAnsiblePlaybook(config.AnsiblePath+"/setup.yaml", host.Name, map[string]string{"service": host.Name})
 			if err != nil {
@@ -103,7 +103,7 @@ func handleSSHKeysAndServiceChecks() {
 				break // Key is already exported, exit the retry loop
 			}
 			common.Info("Setting up SSH key for host %s (attempt %d)\n", host.Name, retry+1)
-			common.SendMessageToTelegram("**DEPLOYER** ::: Setting up SSH key for host " + host.Name + " (attempt " + string(rune(retry+1)) + ")")
+			common.SendMessageToTelegram("DEPLOYER ::: Setting up SSH key for host " + host.Name + " (attempt " + string(rune(retry+1)) + ")")
 			err := setupSSHKeyForHost("master", host.Name, host.Ip)
 			if err != nil {
 				common.Warn("Error setting up SSH keys for host %s: %v", host.Name, err)
@@ -114,7 +114,7 @@ func handleSSHKeysAndServiceChecks() {
 				common.Err("Failed to set up SSH keys for host %s after %d attempts: %v", host.Name, maxRetries, err)
 			}
 			common.Ok("SSH key setup successful for host %s\n", host.Name)
-			common.SendMessageToTelegram("**DEPLOYER** ::: SSH key setup successful for host " + host.Name)
+			common.SendMessageToTelegram("DEPLOYER ::: SSH key setup successful for host " + host.Name)
 			break // Key setup successful, exit the loop
 		}
 	}
@@ -131,7 +131,7 @@ func main() {
 		common.Warn("PYTHON_API_HOST not set, using default value")
 		common.PYTHON_API_HOST = "http://192.168.4.99"
 	}
-	common.SendMessageToTelegram("**DEPLOYER** ::: Service started")
+	common.SendMessageToTelegram("DEPLOYER ::: Service started")
 	client = NewGitHubClient(os.Getenv("GITHUB_TOKEN"))
 
 	// 1. Load configuration
@@ -13,59 +13,64 @@ import (
 )
 
 // handleDynoxyCreate handles messages for creating subdomains
-func handleDynoxyCreate(delivery amqp.Delivery) error {
+func handleDynoxyCreate(ctx context.Context, delivery amqp.Delivery) error {
 	// Unmarshal the message
 	var message rabbitmq.DynoxyMessage
 	if err := json.Unmarshal(delivery.Body, &message); err != nil {
-		common.Err("Error unmarshalling dynoxy.create message: %v", err)
-		return delivery.Nack(false, true) // Nack to requeue
+		return rabbitmq.Permanent(fmt.Errorf("error unmarshalling dynoxy.create message: %w", err))
 	}
-	common.Info("Received message: %+v", message)
+	ctx = common.WithLogFields(ctx,
+		common.FieldMachineID, message.MachineID.String(),
+		common.FieldUserID, message.UserID.String())
+	common.Logger(ctx).Info("Received message", "event", message.Event, "route_id", message.RouteID.String())
 
 	// Generate the subdomain
 	subdomain := generateSubdomain(message.MachineID, message.UserID, message.Port)
-	common.Info("Generated subdomain: %s", subdomain)
+	common.Logger(ctx).Info("Generated subdomain", "subdomain", subdomain)
 
 	// Get the container IP address
-	containerIP, err := getContainerIP(message.MachineID.String()) // Assuming MachineID is the container ID
+	containerIP, err := getContainerIP(ctx, message.MachineID.String()) // Assuming MachineID is the container ID
 	if err != nil {
-		common.Err("Error getting container IP: %v", err)
-		return delivery.Nack(false, true) // Nack to requeue
+		return fmt.Errorf("error getting container IP: %w", err)
 	}
-	common.Info("Container IP: %s", containerIP)
+	common.Logger(ctx).Info("Resolved container IP", "container_ip", containerIP)
 
 	// Configure Traefik
-	if err := configureTraefik(subdomain, containerIP, message.Port); err != nil {
-		common.Err("Error configuring Traefik: %v", err)
-		return delivery.Nack(false, true) // Nack to requeue
+	spanCtx, span := common.StartSpan(ctx, "traefik configure route")
+	err = configureTraefik(spanCtx, subdomain, containerIP, message.Port)
+	span.End()
+	if err != nil {
+		return fmt.Errorf("error configuring Traefik: %w", err)
 	}
 
-	// Acknowledge the message after successful processing
-	return delivery.Ack(false)
+	// The consumer acknowledges the message after successful processing
+	return nil
 }
 
 // handleDynoxyDelete handles messages for deleting subdomains
-func handleDynoxyDelete(delivery amqp.Delivery) error {
+func handleDynoxyDelete(ctx context.Context, delivery amqp.Delivery) error {
 	// Unmarshal the message
 	var message rabbitmq.DynoxyMessage
 	if err := json.Unmarshal(delivery.Body, &message); err != nil {
-		common.Err("Error unmarshalling dynoxy.delete message: %v", err)
-		return delivery.Nack(false, true) // Nack to requeue
+		return rabbitmq.Permanent(fmt.Errorf("error unmarshalling dynoxy.delete message: %w", err))
 	}
-	common.Info("Received message: %+v", message)
+	ctx = common.WithLogFields(ctx,
+		common.FieldMachineID, message.MachineID.String(),
+		common.FieldUserID, message.UserID.String())
+	common.Logger(ctx).Info("Received message", "event", message.Event, "route_id", message.RouteID.String())
 
 	// Generate the subdomain (to be removed)
 	subdomain := generateSubdomain(message.MachineID, message.UserID, message.Port)
 
 	// Remove the subdomain from Traefik
-	if err := removeSubdomain(subdomain); err != nil {
+	if err := removeSubdomain(ctx, subdomain); err != nil {
 		// It's likely okay to log the error and acknowledge the message,
 		// even if subdomain removal fails. The container is likely already gone.
-		common.Err("Error removing subdomain from Traefik: %v", err)
+		common.Logger(ctx).Error(fmt.Sprintf("Error removing subdomain from Traefik: %v", err))
 	}
 
-	// Acknowledge the message
-	return delivery.Ack(false)
+	// The consumer acknowledges the message
+	return nil
 }
 
 // generateSubdomain creates a subdomain string
@@ -74,13 +79,13 @@ func generateSubdomain(machineID, userID uuid.UUID, port int) string {
 }
 
 // getContainerIP retrieves the IP address of a container
-func getContainerIP(containerID string) (string, error) {
+func getContainerIP(ctx context.Context, containerID string) (string, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return "", fmt.Errorf("error creating Docker client: %w", err)
 	}
 
-	containerJSON, err := cli.ContainerInspect(context.Background(), containerID)
+	containerJSON, err := cli.ContainerInspect(ctx, containerID)
 	if err != nil {
 		return "", fmt.Errorf("error inspecting container: %w", err)
 	}
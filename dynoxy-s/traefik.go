@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,7 +10,7 @@ import (
 	"github.com/nesiler/cestx/common"
 )
 
-func configureTraefik(subdomain, containerIP string, port int) error {
+func configureTraefik(ctx context.Context, subdomain, containerIP string, port int) error {
 	// 1. Construct the Traefik API payload
 	// Assuming you are using docker provider, you can use labels for dynamic configuration.
 	// This configuration creates a new service and a new router, and links them together.
@@ -52,7 +53,7 @@ func configureTraefik(subdomain, containerIP string, port int) error {
 
 	// 2. Make the API request to Traefik
 	traefikURL := "http://localhost:8080/api/providers/docker/configure" // Update with your Traefik API endpoint
-	req, err := http.NewRequest(http.MethodPost, traefikURL, bytes.NewBuffer(jsonConfig))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, traefikURL, bytes.NewBuffer(jsonConfig))
 	if err != nil {
 		return common.Err("Failed to create request: %w", err)
 	}
@@ -73,10 +74,10 @@ func configureTraefik(subdomain, containerIP string, port int) error {
 	return nil
 }
 
-func removeSubdomain(subdomain string) error {
+func removeSubdomain(ctx context.Context, subdomain string) error {
 	// 1. Make a DELETE request to remove the router
 	routerURL := fmt.Sprintf("http://localhost:8080/api/providers/docker/routers/%s", subdomain)
-	reqRouter, err := http.NewRequest(http.MethodDelete, routerURL, nil)
+	reqRouter, err := http.NewRequestWithContext(ctx, http.MethodDelete, routerURL, nil)
 	if err != nil {
 		return common.Err("Failed to create router delete request: %w", err)
 	}
@@ -97,7 +98,7 @@ func removeSubdomain(subdomain string) error {
 
 	// 2. Make a DELETE request to remove the service
 	serviceURL := fmt.Sprintf("http://localhost:8080/api/providers/docker/services/%s", subdomain)
-	reqService, err := http.NewRequest(http.MethodDelete, serviceURL, nil)
+	reqService, err := http.NewRequestWithContext(ctx, http.MethodDelete, serviceURL, nil)
 	if err != nil {
 		return common.Err("Failed to create service delete request: %w", err)
 	}
@@ -15,9 +15,20 @@ import (
 	"github.com/nesiler/cestx/common"
 )
 
+// pingDocker checks that the Docker daemon answers; used as a health check.
+func pingDocker(ctx context.Context) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("Error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	_, err = cli.Ping(ctx)
+	return err
+}
+
 // buildImage builds a Docker image from the specified Dockerfile.
-func buildImage(dockerfilePath, imageName string) (string, error) {
-	ctx := context.Background()
+func buildImage(ctx context.Context, dockerfilePath, imageName string) (string, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return "", fmt.Errorf("Error creating Docker client: %v", err)
@@ -53,8 +64,7 @@ func buildImage(dockerfilePath, imageName string) (string, error) {
 }
 
 // runContainer runs a Docker container with the specified image and settings.
-func runContainer(imageName, containerName string, ports []string, resources ...string) (string, error) {
-	ctx := context.Background()
+func runContainer(ctx context.Context, imageName, containerName string, ports []string, resources ...string) (string, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return "", fmt.Errorf("Error creating Docker client: %v", err)
@@ -134,8 +144,7 @@ func runContainer(imageName, containerName string, ports []string, resources ...
 }
 
 // startContainer starts a stopped Docker container.
-func startContainer(containerID string) error {
-	ctx := context.Background()
+func startContainer(ctx context.Context, containerID string) error {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return fmt.Errorf("Error creating Docker client: %v", err)
@@ -148,8 +157,7 @@ func startContainer(containerID string) error {
 }
 
 // stopContainer stops a running Docker container.
-func stopContainer(containerID string) error {
-	ctx := context.Background()
+func stopContainer(ctx context.Context, containerID string) error {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return fmt.Errorf("Error creating Docker client: %v", err)
@@ -162,8 +170,7 @@ func stopContainer(containerID string) error {
 }
 
 // removeContainer removes a Docker container.
-func removeContainer(containerID string) error {
-	ctx := context.Background()
+func removeContainer(ctx context.Context, containerID string) error {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return fmt.Errorf("Error creating Docker client: %v", err)
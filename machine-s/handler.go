@@ -1,96 +1,132 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/nesiler/cestx/common"
+	"github.com/nesiler/cestx/postgresql/models"
 	"github.com/nesiler/cestx/rabbitmq"
 	amqp "github.com/streadway/amqp"
 )
 
-// handleMessage processes RabbitMQ messages for machine operations.
-func handleMessage(delivery amqp.Delivery, amqpConn *amqp.Connection) error {
+// handleMessage processes RabbitMQ messages for machine operations and
+// returns the resulting machine, which is sent back to callers using Call.
+// ctx carries the trace of the message and is passed down to every operation.
+func handleMessage(ctx context.Context, delivery amqp.Delivery, amqpConn *rabbitmq.ManagedConnection) (*rabbitmq.MachineResult, error) {
 	// 1. Unmarshal the message
 	var machineMessage rabbitmq.MachineMessage
 	if err := json.Unmarshal(delivery.Body, &machineMessage); err != nil {
-		// Log the error, but don't return it (Nack below)
-		common.Err("Error unmarshalling machine message: %v", err)
-		return delivery.Nack(false, true) // Nack to requeue
+		// Malformed messages never succeed; park them instead of retrying
+		return nil, rabbitmq.Permanent(fmt.Errorf("error unmarshalling machine message: %w", err))
 	}
 
-	common.Info("Received message: %+v", machineMessage)
+	// Retries and replays keep the message ID, so they create the same machine
+	if machineMessage.Event == rabbitmq.MachineCreate && machineMessage.MachineID == uuid.Nil && delivery.MessageId != "" {
+		machineMessage.MachineID = uuid.NewSHA1(uuid.NameSpaceOID, []byte(delivery.MessageId))
+	}
+
+	ctx = common.WithLogFields(ctx,
+		common.FieldMachineID, machineMessage.MachineID.String(),
+		common.FieldUserID, machineMessage.UserID.String())
+	common.Logger(ctx).Info("Received message", "event", machineMessage.Event)
 
-	// 2. Handle different machine events
+	// 2. Handle different machine events; the consumer acks on success and
+	// retries or parks the message on error
+	var result *rabbitmq.MachineResult
+	var err error
 	switch machineMessage.Event {
 	case rabbitmq.MachineCreate:
-		return handleCreateMachine(machineMessage, amqpConn)
+		result, err = handleCreateMachine(ctx, machineMessage, amqpConn)
 	case rabbitmq.MachineStart:
-		return handleStartMachine(machineMessage, amqpConn)
+		result, err = handleStartMachine(ctx, machineMessage, amqpConn)
 	case rabbitmq.MachineStop:
-		return handleStopMachine(machineMessage, amqpConn)
+		result, err = handleStopMachine(ctx, machineMessage, amqpConn)
 	case rabbitmq.MachineDelete:
-		return handleDeleteMachine(machineMessage, amqpConn)
+		result, err = handleDeleteMachine(ctx, machineMessage, amqpConn)
 	default:
-		// Handle unknown events (you might choose to Nack instead)
-		common.Warn("Unknown machine event: %s", machineMessage.Event)
-		return delivery.Nack(false, false) // Nack without requeue (dead-letter queue might be better)
+		return nil, rabbitmq.Permanent(fmt.Errorf("unknown machine event: %s", machineMessage.Event))
+	}
+
+	// A missing machine or template does not show up on a retry
+	if errors.Is(err, common.ErrNotFound) {
+		return nil, rabbitmq.Permanent(err)
+	}
+	return result, err
+}
+
+// machineResult describes a machine record in a reply.
+func machineResult(machine *models.Machine) *rabbitmq.MachineResult {
+	return &rabbitmq.MachineResult{
+		MachineID: machine.ID,
+		Name:      machine.Name,
+		Running:   machine.Status,
+		URL:       machine.URL,
+		ExpiresAt: machine.ExpiresAt,
 	}
 }
 
 // Individual handler functions for each machine event:
 
-func handleCreateMachine(msg rabbitmq.MachineMessage, amqpConn *amqp.Connection) error {
+func handleCreateMachine(ctx context.Context, msg rabbitmq.MachineMessage, amqpConn *rabbitmq.ManagedConnection) (*rabbitmq.MachineResult, error) {
 	// Implement machine creation logic
-	if err := CreateMachine(msg, amqpConn); err != nil {
+	machine, err := CreateMachine(ctx, msg, amqpConn)
+	if err != nil {
 		// Handle errors (e.g., log, Nack the message, etc.)
-		return fmt.Errorf("failed to create machine: %w", err)
+		return nil, fmt.Errorf("failed to create machine: %w", err)
 	}
-	return nil
+	return machineResult(machine), nil
 }
 
-func handleStartMachine(msg rabbitmq.MachineMessage, amqpConn *amqp.Connection) error {
+func handleStartMachine(ctx context.Context, msg rabbitmq.MachineMessage, amqpConn *rabbitmq.ManagedConnection) (*rabbitmq.MachineResult, error) {
 	// You'll likely need the machine ID to start a specific machine
 	machineID, err := uuid.Parse(msg.MachineID.String())
 	if err != nil {
-		return fmt.Errorf("invalid machine ID: %w", err)
+		return nil, rabbitmq.Permanent(fmt.Errorf("invalid machine ID: %w", err))
 	}
 
 	// Implement machine start logic
-	if err := StartMachine(machineID, amqpConn); err != nil {
+	machine, err := StartMachine(ctx, machineID, amqpConn)
+	if err != nil {
 		// Handle errors (e.g., log, Nack the message, etc.)
-		return fmt.Errorf("failed to start machine: %w", err)
+		return nil, fmt.Errorf("failed to start machine: %w", err)
 	}
-	return nil
+	return machineResult(machine), nil
 }
 
-func handleStopMachine(msg rabbitmq.MachineMessage, amqpConn *amqp.Connection) error {
+func handleStopMachine(ctx context.Context, msg rabbitmq.MachineMessage, amqpConn *rabbitmq.ManagedConnection) (*rabbitmq.MachineResult, error) {
 	// You'll likely need the machine ID to start a specific machine
 	machineID, err := uuid.Parse(msg.MachineID.String())
 	if err != nil {
-		return fmt.Errorf("invalid machine ID: %w", err)
+		return nil, rabbitmq.Permanent(fmt.Errorf("invalid machine ID: %w", err))
 	}
 
 	// Implement machine stop logic
-	if err := StopMachine(machineID, amqpConn); err != nil {
+	machine, err := StopMachine(ctx, machineID, amqpConn)
+	if err != nil {
 		// Handle errors (e.g., log, Nack the message, etc.)
-		return fmt.Errorf("failed to stop machine: %w", err)
+		return nil, fmt.Errorf("failed to stop machine: %w", err)
 	}
-	return nil
+	return machineResult(machine), nil
 }
 
-func handleDeleteMachine(msg rabbitmq.MachineMessage, amqpConn *amqp.Connection) error {
+func handleDeleteMachine(ctx context.Context, msg rabbitmq.MachineMessage, amqpConn *rabbitmq.ManagedConnection) (*rabbitmq.MachineResult, error) {
 	// You'll likely need the machine ID to start a specific machine
 	machineID, err := uuid.Parse(msg.MachineID.String())
 	if err != nil {
-		return fmt.Errorf("invalid machine ID: %w", err)
+		return nil, rabbitmq.Permanent(fmt.Errorf("invalid machine ID: %w", err))
 	}
 
 	// Implement machine deletion logic
-	if err := DeleteMachine(machineID, amqpConn); err != nil {
+	machine, err := DeleteMachine(ctx, machineID, amqpConn)
+	if err != nil {
 		// Handle errors (e.g., log, Nack the message, etc.)
-		return fmt.Errorf("failed to delete machine: %w", err)
+		return nil, fmt.Errorf("failed to delete machine: %w", err)
 	}
-	return nil
+	result := machineResult(machine)
+	result.Running = false
+	return result, nil
 }
@@ -3,13 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
-	"github.com/joho/godotenv"
 	mc "github.com/minio/minio-go/v7"
 	"github.com/nesiler/cestx/common"
 	"github.com/nesiler/cestx/minio"
@@ -23,82 +17,98 @@ import (
 
 // Declare global variables for clients
 var (
-	minioClient    *mc.Client
-	postgresClient *gc.DB
-	redisClient    *rc.Client
-	amqpConn       *amqp.Connection
+	minioClient     *mc.Client
+	templatesBucket string // bucket of the Dockerfiles, MINIO_TEMPLATES_BUCKET
+	postgresClient  *gc.DB
+	redisClient     *rc.Client
+	amqpConn        *rabbitmq.ManagedConnection
 )
 
 func main() {
-	// 1. Load Environment Variables
-	godotenv.Load("../.env")
-	godotenv.Load(".env")
-
-	common.PYTHON_API_HOST = common.GetEnv("PYTHON_API_HOST", "")
-	common.TELEGRAM_TOKEN = common.GetEnv("TELEGRAM_TOKEN", "")
-	common.CHAT_ID = common.GetEnv("CHAT_ID", "")
-	common.REGISTRY_HOST = common.GetEnv("REGISTRY_HOST", "")
-
-	// Read the service configuration from service.json
-	serviceData, err := os.ReadFile("service.json")
-	common.FailError(err, "Failed to read service configuration: %v\n", err)
-
-	// Load service configuration
-	service, err := common.LoadServiceConfig(serviceData)
+	// 1. Load the environment and service.json
+	service, err := common.NewService("service.json")
 	common.FailError(err, "Failed to load service configuration: %v\n", err)
 
-	// 3. Register Service
-	go registerService(service)
-	go healthCheck(service)
-
-	// 2. Initialize Clients
-	InitializeClients()
-	defer closeClients() // Ensure graceful shutdown
+	// 2. Initialize Clients, closed again once the consumers have drained
+	service.OnInit(func(ctx context.Context) error {
+		return InitializeClients()
+	})
+	service.OnShutdown(func(ctx context.Context) error {
+		closeClients()
+		return nil
+	})
+
+	// 3. Readiness reflects every dependency machine operations need
+	service.Health.Register("postgres", func(ctx context.Context) error {
+		return postgresql.Ping(ctx, postgresClient)
+	})
+	service.Health.Register("redis", func(ctx context.Context) error {
+		return redis.Ping(ctx, redisClient)
+	})
+	service.Health.Register("rabbitmq", func(ctx context.Context) error {
+		return amqpConn.Ping(ctx)
+	})
+	service.Health.Register("minio", func(ctx context.Context) error {
+		return minio.Ping(ctx, minioClient, templatesBucket)
+	})
+	service.Health.Register("docker", pingDocker)
 
 	// 4. Start Consuming Messages
-	common.Head("Starting Machine Service...")
-	common.SendMessageToTelegram("**MACHINE SERVICE** ::: Service starting...")
-
-	if err := consumeMessages(); err != nil {
-		common.Fatal("Error consuming messages: %v", err)
+	// Requests sent with Call get the resulting machine or the error back
+	handle := rabbitmq.RPC(func(ctx context.Context, delivery amqp.Delivery) (interface{}, error) {
+		return handleMessage(ctx, delivery, amqpConn)
+	})
+	service.Go(rabbitmq.QueueMachineCreate, consumeQueue(rabbitmq.QueueMachineCreate, handle))
+	service.Go(rabbitmq.QueueMachineStart, consumeQueue(rabbitmq.QueueMachineStart, handle))
+
+	// 5. Register, serve /health and run until SIGTERM, then drain gracefully
+	if err := service.Run(); err != nil {
+		common.Fatal("Machine service stopped: %v", err)
 	}
-
-	// 5. Graceful Shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM) // Capture interrupt signals
-	<-quit                                             // Block until an interrupt is received
-
-	common.Info("Machine service stopping...")
-	common.SendMessageToTelegram("**MACHINE SERVICE** ::: Service stopping...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	<-ctx.Done()
-	common.Ok("Machine service stopped successfully")
 }
 
 // InitializeClients sets up connections to external services.
-func InitializeClients() {
-	var err error
+func InitializeClients() error {
 	// Initialize Minio client
-	minioCfg := common.LoadMinIOConfig()
-	minioClient, _ = minio.NewMinIOClient(minioCfg)
+	minioCfg, err := common.LoadMinIOConfig()
+	if err != nil {
+		return err
+	}
+	minioClient, err = minio.NewMinIOClient(minioCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create Minio client: %w", err)
+	}
+	templatesBucket = minioCfg.TemplatesBucket
 
 	// Initialize PostgreSQL client
-	dbCfg := common.LoadPostgreSQLConfig()
-	postgresClient, _ = postgresql.NewPostgreSQLDB(dbCfg)
+	dbCfg, err := common.LoadPostgreSQLConfig()
+	if err != nil {
+		return err
+	}
+	postgresClient, err = postgresql.NewPostgreSQLDB(dbCfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
 
 	// Initialize Redis client
-	redisCfg := common.LoadRedisConfig()
-	redisClient, _ = redis.NewRedisClient(redisCfg)
+	redisCfg, err := common.LoadRedisConfig()
+	if err != nil {
+		return err
+	}
+	redisClient, err = redis.NewRedisClient(redisCfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
 
 	// Initialize RabbitMQ connection
-	rabbitCfg := common.LoadRabbitMQConfig()
-	amqpConn, err = rabbitmq.NewConnection(rabbitCfg)
+	rabbitCfg, err := common.LoadRabbitMQConfig()
 	if err != nil {
-		common.Fatal("Failed to connect to RabbitMQ: %v", err)
+		return err
 	}
+	// Declare exchanges, queues and bindings so published messages are routed,
+	// again after every reconnect
+	amqpConn, err = rabbitmq.NewManagedConnection(rabbitCfg, rabbitmq.DeclareTopology)
+	return err
 }
 
 // closeClients closes connections to external services.
@@ -119,70 +129,13 @@ func closeClients() {
 
 }
 
-// registerService registers the machine-s with the registry service.
-func registerService(service *common.ServiceConfig) {
-	var err error
-	// Set the service address
-	service.Address, err = common.ExternalIP()
-	common.FailError(err, "Failed to get external IP: %v\n", err)
-
-	common.REGISTRY_HOST = common.GetEnv("REGISTRY_HOST", "192.168.4.63")
-	common.PYTHON_API_HOST = common.GetEnv("PYTHON_API_HOST", "192.168.4.99")
-
-	// Register the service with the registry
-	err = common.RegisterService(service)
-	if err != nil {
-		// Log the error, retry registration after a delay
-		common.Warn("Failed to register service: %v", err)
-		time.Sleep(5 * time.Second)
-		registerService(service) // Retry registration
-		return
-	}
-
-	// Send a Telegram message on successful registration
-	common.SendMessageToTelegram(fmt.Sprintf("**%s** ::: Service registered successfully!", service.Name))
-}
-
-// consumeMessages sets up consumers for different machine events.
-func consumeMessages() error {
-	// 1. Create a channel for message consumption
-	ch, err := amqpConn.Channel()
-	if err != nil {
-		return fmt.Errorf("failed to open a channel: %w", err)
-	}
-	defer ch.Close() // Close the channel when the function exits
-
-	// 2. Define consumer functions for different events (using closures to capture amqpConn)
-	handleMachineCreate := func(delivery amqp.Delivery) error {
-		return handleMessage(delivery, amqpConn)
-	}
-
-	handleMachineStart := func(delivery amqp.Delivery) error {
-		return handleMessage(delivery, amqpConn)
-	}
-
-	// 3. Start consuming messages from the respective queues
-	if err := rabbitmq.Consume(ch, rabbitmq.QueueMachineCreate, handleMachineCreate); err != nil {
-		return fmt.Errorf("failed to consume from 'machine.create' queue: %w", err)
-	}
-
-	if err := rabbitmq.Consume(ch, rabbitmq.QueueMachineStart, handleMachineStart); err != nil {
-		return fmt.Errorf("failed to consume from 'machine.start' queue: %w", err)
-	}
-
-	// 4. Keep the service running to listen for messages
-	forever := make(chan bool)
-	<-forever
-	return nil
-}
-
-func healthCheck(service *common.ServiceConfig) {
-	// Setup health check endpoint
-	http.HandleFunc("/health", common.HealthHandler())
-
-	// Start the server
-	common.Info("Starting %v on port %d", service.Name, service.Port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", service.Port), nil); err != nil {
-		common.Fatal("Server failed to start: %v\n", err)
+// consumeQueue returns a worker that consumes a queue until ctx is cancelled,
+// resubscribing whenever the RabbitMQ connection comes back.
+func consumeQueue(queue string, handler rabbitmq.Handler) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := amqpConn.Consume(ctx, queue, handler); err != nil {
+			return fmt.Errorf("failed to consume from '%s' queue: %w", queue, err)
+		}
+		return nil
 	}
 }
@@ -44,3 +44,18 @@ func NewMinIOClient(cfg *common.MinIOConfig) (*minio.Client, error) {
 
 	return client, nil
 }
+
+// Ping checks that MinIO answers and the bucket exists; use it as a health check.
+func Ping(ctx context.Context, client *minio.Client, bucketName string) error {
+	if client == nil {
+		return fmt.Errorf("minio client not initialized")
+	}
+	exists, err := client.BucketExists(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket '%s' does not exist", bucketName)
+	}
+	return nil
+}
@@ -53,6 +53,9 @@ func DownloadTemplate(ctx context.Context, client *minio.Client, objectName, loc
 	// Copy the object content to the local file
 	stat, err := object.Stat()
 	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return common.Err("Object '%s' %w in MinIO", objectName, common.ErrNotFound)
+		}
 		return common.Err("Failed to get object stat: %w", err)
 	}
 	if _, err := io.CopyN(file, object, stat.Size); err != nil {
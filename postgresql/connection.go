@@ -1,6 +1,7 @@
 package postgresql
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -33,3 +34,15 @@ func NewPostgreSQLDB(cfg *common.PostgreSQLConfig) (*gorm.DB, error) {
 	common.Ok("Connected to PostgreSQL database successfully!")
 	return db, nil
 }
+
+// Ping checks that the database answers; use it as a health check.
+func Ping(ctx context.Context, db *gorm.DB) error {
+	if db == nil {
+		return fmt.Errorf("postgresql client not initialized")
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
@@ -30,7 +30,7 @@ func NewMachineRepository(db *gorm.DB) MachineRepository {
 func (r *machineRepository) CreateMachine(ctx context.Context, machine *models.Machine) error {
 	result := r.db.WithContext(ctx).Create(machine)
 	if result.Error != nil {
-		return common.Err("Failed to create machine: %v", result.Error)
+		return common.Err("Failed to create machine: %w", result.Error)
 	}
 	return nil
 }
@@ -41,9 +41,9 @@ func (r *machineRepository) GetMachineByID(ctx context.Context, machineID uuid.U
 	result := r.db.WithContext(ctx).First(&machine, "id = ?", machineID)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, common.Err("Machine not found: %v", result.Error)
+			return nil, common.Err("Machine not found: %w", common.ErrNotFound)
 		}
-		return nil, common.Err("Failed to get machine by ID: %v", result.Error)
+		return nil, common.Err("Failed to get machine by ID: %w", result.Error)
 	}
 	return &machine, nil
 }
@@ -52,7 +52,7 @@ func (r *machineRepository) GetMachineByID(ctx context.Context, machineID uuid.U
 func (r *machineRepository) UpdateMachine(ctx context.Context, machine *models.Machine) error {
 	result := r.db.WithContext(ctx).Save(machine)
 	if result.Error != nil {
-		return common.Err("Failed to update machine: %v", result.Error)
+		return common.Err("Failed to update machine: %w", result.Error)
 	}
 	return nil
 }
@@ -61,7 +61,7 @@ func (r *machineRepository) UpdateMachine(ctx context.Context, machine *models.M
 func (r *machineRepository) DeleteMachine(ctx context.Context, machineID uuid.UUID) error {
 	result := r.db.WithContext(ctx).Delete(&models.Machine{}, "id = ?", machineID)
 	if result.Error != nil {
-		return common.Err("Failed to delete machine: %v", result.Error)
+		return common.Err("Failed to delete machine: %w", result.Error)
 	}
 	return nil
 }
@@ -31,7 +31,7 @@ func NewTaskFileRepository(db *gorm.DB) TaskFileRepository {
 func (r *taskFileRepository) CreateTask(ctx context.Context, task *models.Task) error {
 	result := r.db.WithContext(ctx).Create(task)
 	if result.Error != nil {
-		return common.Err("Failed to create task: %v", result.Error)
+		return common.Err("Failed to create task: %w", result.Error)
 	}
 	return nil
 }
@@ -42,9 +42,9 @@ func (r *taskFileRepository) GetTaskByID(ctx context.Context, taskID uuid.UUID)
 	result := r.db.WithContext(ctx).First(&task, "id = ?", taskID)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, common.Err("Task not found: %v", result.Error)
+			return nil, common.Err("Task not found: %w", common.ErrNotFound)
 		}
-		return nil, common.Err("Failed to get task by ID: %v", result.Error)
+		return nil, common.Err("Failed to get task by ID: %w", result.Error)
 	}
 	return &task, nil
 }
@@ -53,7 +53,7 @@ func (r *taskFileRepository) GetTaskByID(ctx context.Context, taskID uuid.UUID)
 func (r *taskFileRepository) CreateFile(ctx context.Context, file *models.File) error {
 	result := r.db.WithContext(ctx).Create(file)
 	if result.Error != nil {
-		return common.Err("Failed to create file: %v", result.Error)
+		return common.Err("Failed to create file: %w", result.Error)
 	}
 	return nil
 }
@@ -64,9 +64,9 @@ func (r *taskFileRepository) GetFileByID(ctx context.Context, fileID uuid.UUID)
 	result := r.db.WithContext(ctx).First(&file, "id = ?", fileID)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, common.Err("File not found: %v", result.Error)
+			return nil, common.Err("File not found: %w", common.ErrNotFound)
 		}
-		return nil, common.Err("Failed to get file by ID: %v", result.Error)
+		return nil, common.Err("Failed to get file by ID: %w", result.Error)
 	}
 	return &file, nil
 }
\ No newline at end of file
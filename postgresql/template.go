@@ -30,7 +30,7 @@ func NewTemplateRepository(db *gorm.DB) TemplateRepository {
 func (r *templateRepository) CreateTemplate(ctx context.Context, template *models.Template) error {
 	result := r.db.WithContext(ctx).Create(template)
 	if result.Error != nil {
-		return common.Err("Failed to create template: %v", result.Error)
+		return common.Err("Failed to create template: %w", result.Error)
 	}
 	return nil
 }
@@ -41,9 +41,9 @@ func (r *templateRepository) GetTemplateByName(ctx context.Context, templateName
 	result := r.db.WithContext(ctx).First(&template, "name = ?", templateName)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, common.Err("Template not found: %v", result.Error)
+			return nil, common.Err("Template not found: %w", common.ErrNotFound)
 		}
-		return nil, common.Err("Failed to get template by name: %v", result.Error)
+		return nil, common.Err("Failed to get template by name: %w", result.Error)
 	}
 	return &template, nil
 }
@@ -54,9 +54,9 @@ func (r *templateRepository) GetTemplateByID(ctx context.Context, templateID uui
 	result := r.db.WithContext(ctx).First(&template, "id = ?", templateID)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, common.Err("Template not found: %v", result.Error)
+			return nil, common.Err("Template not found: %w", common.ErrNotFound)
 		}
-		return nil, common.Err("Failed to get template by ID: %v", result.Error)
+		return nil, common.Err("Failed to get template by ID: %w", result.Error)
 	}
 	return &template, nil
 }
@@ -65,7 +65,7 @@ func (r *templateRepository) GetTemplateByID(ctx context.Context, templateID uui
 func (r *templateRepository) DeleteTemplate(ctx context.Context, templateID uuid.UUID) error {
 	result := r.db.WithContext(ctx).Delete(&models.Template{}, "id = ?", templateID)
 	if result.Error != nil {
-		return common.Err("Failed to delete template: %v", result.Error)
+		return common.Err("Failed to delete template: %w", result.Error)
 	}
 	return nil
 }
@@ -30,7 +30,7 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 func (r *userRepository) CreateUser(ctx context.Context, user *models.User) error {
 	result := r.db.WithContext(ctx).Create(user)
 	if result.Error != nil {
-		return common.Err("Failed to create user: %v", result.Error)
+		return common.Err("Failed to create user: %w", result.Error)
 	}
 	return nil
 }
@@ -41,9 +41,9 @@ func (r *userRepository) GetUserByID(ctx context.Context, userID uuid.UUID) (*mo
 	result := r.db.WithContext(ctx).First(&user, "id = ?", userID)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, common.Err("User not found: %v", result.Error)
+			return nil, common.Err("User not found: %w", common.ErrNotFound)
 		}
-		return nil, common.Err("Failed to get user by ID: %v", result.Error)
+		return nil, common.Err("Failed to get user by ID: %w", result.Error)
 	}
 	return &user, nil
 }
@@ -54,9 +54,9 @@ func (r *userRepository) GetUserByUsername(ctx context.Context, username string)
 	result := r.db.WithContext(ctx).First(&user, "username = ?", username)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, common.Err("User not found: %v", result.Error)
+			return nil, common.Err("User not found: %w", common.ErrNotFound)
 		}
-		return nil, common.Err("Failed to get user by username: %v", result.Error)
+		return nil, common.Err("Failed to get user by username: %w", result.Error)
 	}
 	return &user, nil
 }
\ No newline at end of file
@@ -0,0 +1,93 @@
+// Command dlq inspects and replays messages parked in dead-letter queues.
+//
+//	dlq list                       parked message count of every queue
+//	dlq [-n 20] inspect <queue>    show parked messages without removing them
+//	dlq [-n 0] replay <queue>      move parked messages back to the queue
+//	dlq purge <queue>              drop all parked messages
+//
+// The broker is taken from RABBITMQ_URL, as for the services.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/nesiler/cestx/rabbitmq"
+)
+
+func main() {
+	limit := flag.Int("n", 0, "number of messages, 0 for all (inspect defaults to 20)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dlq [-n count] list | inspect <queue> | replay <queue> | purge <queue>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 || (args[0] != "list" && len(args) != 2) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := common.LoadRabbitMQConfig()
+	common.FailError(err, "Failed to load RabbitMQ configuration: %v", err)
+	conn, err := rabbitmq.NewConnection(cfg)
+	common.FailError(err, "%v", err)
+	defer conn.Close()
+
+	// Make sure every dead-letter queue exists before touching one
+	err = rabbitmq.DeclareTopology(conn)
+	common.FailError(err, "%v", err)
+	ch, err := conn.Channel()
+	common.FailError(err, "Failed to open a channel: %v", err)
+	defer ch.Close()
+
+	switch args[0] {
+	case "list":
+		for _, q := range rabbitmq.DefaultTopology.Queues {
+			if q.Retry == nil {
+				continue
+			}
+			n, err := rabbitmq.Parked(ch, q.Name)
+			common.FailError(err, "%v", err)
+			fmt.Printf("%-24s %d\n", q.Name, n)
+		}
+
+	case "inspect":
+		n := *limit
+		if n == 0 {
+			n = 20
+		}
+		parked, err := rabbitmq.Inspect(ch, args[1], n)
+		common.FailError(err, "%v", err)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		for _, msg := range parked {
+			enc.Encode(msg)
+		}
+
+	case "replay":
+		pubCh, err := conn.Channel()
+		common.FailError(err, "Failed to open a channel: %v", err)
+		defer pubCh.Close()
+		publisher, err := rabbitmq.NewPublisher(pubCh)
+		common.FailError(err, "%v", err)
+
+		n, err := rabbitmq.Replay(context.Background(), ch, publisher, args[1], *limit)
+		common.FailError(err, "%v", err)
+		common.Ok("Replayed %d message(s) to '%s'", n, args[1])
+
+	case "purge":
+		n, err := rabbitmq.Purge(ch, args[1])
+		common.FailError(err, "%v", err)
+		common.Ok("Dropped %d message(s) parked from '%s'", n, args[1])
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -1,6 +1,7 @@
 package rabbitmq
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -47,3 +48,14 @@ func connect(cfg *common.RabbitMQConfig) (*amqp.Connection, error) {
 
 	return nil, fmt.Errorf("failed to connect to RabbitMQ after %d retries: %w", retries, err)
 }
+
+// Ping checks that the connection is open; use it as a health check.
+func Ping(ctx context.Context, conn *amqp.Connection) error {
+	if conn == nil {
+		return fmt.Errorf("rabbitmq connection not initialized")
+	}
+	if conn.IsClosed() {
+		return fmt.Errorf("rabbitmq connection closed")
+	}
+	return nil
+}
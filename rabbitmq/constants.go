@@ -7,6 +7,7 @@ const (
 	ExchangeDynoxy     = "dynoxy"
 	ExchangeTaskmaster = "taskmaster"
 	ExchangeTemplate   = "template"
+	ExchangeRegistry   = "registry"
 )
 
 // Queues
@@ -31,3 +32,10 @@ const (
 	QueueTaskmasterSSH     = "taskmaster.ssh"
 	QueueTaskmasterScript  = "taskmaster.script"
 )
+
+// Routing keys
+const (
+	RoutingRegistryAll = "registry.#" // Binding key for every registry event
+	RoutingMachineAll  = "machine.#"  // Binding key for every machine event
+	RoutingDynoxyAll   = "dynoxy.#"   // Binding key for every dynoxy event
+)
@@ -1,35 +1,96 @@
 package rabbitmq
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
 	"github.com/nesiler/cestx/common"
 	"github.com/streadway/amqp"
 )
 
+// Handler processes one delivery. ctx carries the trace continued from the
+// message headers and the request ID for common.Logger; pass it on to
+// PublishContext and other calls so the whole chain ends up in one trace.
+type Handler func(ctx context.Context, d amqp.Delivery) error
+
 // Consume consumes messages from the specified queue.
 // It takes a RabbitMQConnection, the queue name, and a handler function
-// to process each received message.
+// to process each received message. The channel is put in confirm mode for
+// retries and replies; use it for nothing else.
 func Consume(ch *amqp.Channel, queueName string, handler func(amqp.Delivery) error) error {
+	q := DefaultTopology.Queue(queueName)
+	msgs, _, publisher, err := startConsumer(ch, q)
+	if err != nil {
+		return err
+	}
+
+	// Process messages asynchronously
+	go func() {
+		for d := range msgs {
+			process(publisher, q, d, func(_ context.Context, d amqp.Delivery) error {
+				return handler(d)
+			})
+		}
+	}()
+
+	return nil
+}
+
+// ConsumeContext consumes messages like Consume but blocks until ctx is
+// cancelled or the channel closes. On cancellation it stops the delivery of
+// new messages and returns once the message being handled has been acked,
+// so a service can drain its consumers before shutting down. Like Consume,
+// it puts the channel in confirm mode.
+func ConsumeContext(ctx context.Context, ch *amqp.Channel, queueName string, handler Handler) error {
+	q := DefaultTopology.Queue(queueName)
+	msgs, tag, publisher, err := startConsumer(ch, q)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := ch.Cancel(tag, false); err != nil {
+				common.Warn("Failed to cancel consumer for queue '%s': %v", queueName, err)
+			}
+			// Messages already delivered to us are requeued when the channel closes
+			common.Info("Consumer for queue '%s' stopped", queueName)
+			return nil
+		case d, ok := <-msgs:
+			if !ok {
+				return common.Err("Delivery channel for queue '%s' closed", queueName)
+			}
+			process(publisher, q, d, handler)
+		}
+	}
+}
+
+// startConsumer declares the queue and registers a consumer on it. It also
+// returns the Publisher that retries and replies go out through, so a
+// message is only acked once its republished copy is confirmed.
+func startConsumer(ch *amqp.Channel, q Queue) (<-chan amqp.Delivery, string, *Publisher, error) {
+	queueName := q.Name
 	if ch == nil {
-		return common.Err("Channel is nil")
+		return nil, "", nil, common.Err("Channel is nil")
 	}
 
-	// Declare the queue (makes the consumer idempotent)
-	_, err := ch.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
+	// Declare the queue as the topology defines it (makes the consumer idempotent)
+	if err := declareQueue(ch, q); err != nil {
+		return nil, "", nil, common.Err("%v", err)
+	}
+
+	publisher, err := NewPublisher(ch)
 	if err != nil {
-		return common.Err("Failed to declare queue '%s': %v", queueName, err)
+		return nil, "", nil, err
 	}
 
-	// Register the consumer
+	// Register the consumer under a known tag so it can be cancelled
+	tag := queueName + "-" + uuid.NewString()
 	msgs, err := ch.Consume(
 		queueName, // queue
-		"",        // consumer
+		tag,       // consumer
 		false,     // auto-ack (set to false to manually ack/nack)
 		false,     // exclusive
 		false,     // no-local
@@ -37,31 +98,27 @@ func Consume(ch *amqp.Channel, queueName string, handler func(amqp.Delivery) err
 		nil,       // args
 	)
 	if err != nil {
-		return common.Err("Failed to register a consumer: %w", err)
+		return nil, "", nil, common.Err("Failed to register a consumer: %w", err)
 	}
 
 	common.Ok("Consumer started successfully for queue '%s'", queueName)
+	return msgs, tag, publisher, nil
+}
 
-	// Process messages asynchronously
-	go func() {
-		for d := range msgs {
-			// Process the message in the handler function
-			err := handler(d)
-			if err != nil {
-				common.Err("Error processing message: %v", err)
-
-				// Example: Negatively acknowledge the message with requeue
-				if err := d.Nack(false, true); err != nil {
-					common.Err("Failed to Nack the message: %v", err)
-				}
-			} else {
-				// Acknowledge the message if processed successfully
-				if err := d.Ack(false); err != nil {
-					common.Err("Failed to acknowledge message: %v", err)
-				}
-			}
+// process runs the handler in a consumer span and acks the message, or
+// hands it to the queue's retry policy on error.
+func process(publisher *Publisher, q Queue, d amqp.Delivery, handler Handler) {
+	ctx, span := startConsumerSpan(d)
+	ctx = context.WithValue(withRequestID(ctx, d), publisherKey{}, publisher)
+	err := handler(ctx, d)
+	endSpan(span, err)
+	if err != nil {
+		common.Logger(ctx).Error(fmt.Sprintf("Error processing message: %v", err))
+		fail(ctx, publisher, q, d, err)
+	} else {
+		// Acknowledge the message if processed successfully
+		if err := d.Ack(false); err != nil {
+			common.Err("Failed to acknowledge message: %v", err)
 		}
-	}()
-
-	return nil
+	}
 }
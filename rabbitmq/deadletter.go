@@ -0,0 +1,116 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ParkedMessage is a message waiting in a dead-letter queue.
+type ParkedMessage struct {
+	MessageID string    `json:"message_id,omitempty"`
+	Queue     string    `json:"queue"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	Body      string    `json:"body"`
+}
+
+// Inspect returns up to limit parked messages of queue, oldest first,
+// without removing them. A limit of 0 or less returns all of them.
+func Inspect(ch *amqp.Channel, queue string, limit int) ([]ParkedMessage, error) {
+	dlq := DeadLetterQueueName(queue)
+
+	var parked []ParkedMessage
+	var last uint64
+	for limit <= 0 || len(parked) < limit {
+		d, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %w", dlq, err)
+		}
+		if !ok {
+			break
+		}
+		last = d.DeliveryTag
+		parked = append(parked, parkedMessage(queue, d))
+	}
+
+	// Put everything back where it was
+	if last != 0 {
+		if err := ch.Nack(last, true, true); err != nil {
+			return nil, fmt.Errorf("failed to return messages to '%s': %w", dlq, err)
+		}
+	}
+	return parked, nil
+}
+
+// Replay moves up to limit parked messages of queue back to it with a
+// fresh retry count and returns how many were moved. A limit of 0 or less
+// replays all of them. Messages are read from ch and republished through
+// publisher, which needs a channel of its own; a parked message is removed
+// only once the broker confirmed its copy, and stays parked otherwise.
+func Replay(ctx context.Context, ch *amqp.Channel, publisher *Publisher, queue string, limit int) (int, error) {
+	dlq := DeadLetterQueueName(queue)
+
+	replayed := 0
+	for limit <= 0 || replayed < limit {
+		d, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to read '%s': %w", dlq, err)
+		}
+		if !ok {
+			break
+		}
+
+		msg := republish(d)
+		for _, header := range []string{HeaderRetryCount, HeaderLastError, HeaderOriginalQueue, "x-death"} {
+			delete(msg.Headers, header)
+		}
+		if err := publisher.PublishMessage(ctx, "", queue, msg); err != nil {
+			if nackErr := d.Nack(false, true); nackErr != nil {
+				err = fmt.Errorf("%w; failed to return it to '%s': %v", err, dlq, nackErr)
+			}
+			return replayed, fmt.Errorf("failed to replay message to '%s': %w", queue, err)
+		}
+		if err := d.Ack(false); err != nil {
+			return replayed, fmt.Errorf("failed to remove replayed message from '%s': %w", dlq, err)
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// Purge drops every parked message of queue and returns how many were dropped.
+func Purge(ch *amqp.Channel, queue string) (int, error) {
+	n, err := ch.QueuePurge(DeadLetterQueueName(queue), false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge '%s': %w", DeadLetterQueueName(queue), err)
+	}
+	return n, nil
+}
+
+// Parked returns the number of parked messages of queue.
+func Parked(ch *amqp.Channel, queue string) (int, error) {
+	q, err := ch.QueueInspect(DeadLetterQueueName(queue))
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect '%s': %w", DeadLetterQueueName(queue), err)
+	}
+	return q.Messages, nil
+}
+
+func parkedMessage(queue string, d amqp.Delivery) ParkedMessage {
+	lastError, _ := d.Headers[HeaderLastError].(string)
+	if original, ok := d.Headers[HeaderOriginalQueue].(string); ok {
+		queue = original
+	}
+	return ParkedMessage{
+		MessageID: d.MessageId,
+		Queue:     queue,
+		Attempts:  RetryCount(d),
+		LastError: lastError,
+		Timestamp: d.Timestamp,
+		Body:      string(d.Body),
+	}
+}
@@ -0,0 +1,343 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/streadway/amqp"
+)
+
+// ConnState is the state of a ManagedConnection.
+type ConnState int32
+
+const (
+	StateConnecting   ConnState = iota // first connection not established yet
+	StateConnected                     // connection open
+	StateReconnecting                  // connection lost, redialing
+	StateClosed                        // closed by Close
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int32(s))
+}
+
+// ErrNotConnected is returned while a ManagedConnection is down.
+var ErrNotConnected = errors.New("rabbitmq not connected")
+
+// SetupFunc prepares a fresh connection, e.g. DeclareTopology. It runs
+// after the first connect and after every reconnect.
+type SetupFunc func(conn *amqp.Connection) error
+
+// ManagedConnection keeps a RabbitMQ connection open. It watches the
+// connection with NotifyClose and redials with exponential backoff (1s up
+// to MaxBackoff) until Close is called. Channels are cheap and die with
+// the connection, so callers open them through Channel for each use, and
+// consumers run through Consume, which resubscribes after a reconnect.
+//
+//	conn, err := rabbitmq.NewManagedConnection(cfg, rabbitmq.DeclareTopology)
+//	service.Health.Register("rabbitmq", conn.Ping)
+//	service.Go(queue, func(ctx context.Context) error {
+//		return conn.Consume(ctx, queue, handler)
+//	})
+type ManagedConnection struct {
+	// Prefetch is the QoS prefetch count of consumer channels.
+	Prefetch int
+	// MaxBackoff bounds the delay between reconnect attempts.
+	MaxBackoff time.Duration
+
+	cfg   *common.RabbitMQConfig
+	setup []SetupFunc
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	state     ConnState
+	lastErr   error
+	connected chan struct{} // closed while connected
+	done      chan struct{} // closed by Close
+}
+
+// NewManagedConnection connects to RabbitMQ, retrying like NewConnection,
+// runs setup and keeps the connection open from then on.
+func NewManagedConnection(cfg *common.RabbitMQConfig, setup ...SetupFunc) (*ManagedConnection, error) {
+	m := newManagedConnection(cfg, setup)
+
+	conn, err := NewConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.runSetup(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	m.setConnected(conn)
+	go m.watch(conn)
+	return m, nil
+}
+
+// DialManagedConnection returns at once and connects in the background,
+// retrying with backoff until it succeeds or Close is called. Until then
+// the connection is in StateConnecting and Channel returns ErrNotConnected.
+// Use it where the broker is optional at startup.
+func DialManagedConnection(cfg *common.RabbitMQConfig, setup ...SetupFunc) *ManagedConnection {
+	m := newManagedConnection(cfg, setup)
+	go func() {
+		if conn := m.reconnect(0); conn != nil {
+			m.watch(conn)
+		}
+	}()
+	return m
+}
+
+func newManagedConnection(cfg *common.RabbitMQConfig, setup []SetupFunc) *ManagedConnection {
+	return &ManagedConnection{
+		Prefetch:   cfg.Prefetch,
+		MaxBackoff: 30 * time.Second,
+		cfg:        cfg,
+		setup:      setup,
+		state:      StateConnecting,
+		connected:  make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// State returns the current connection state.
+func (m *ManagedConnection) State() ConnState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Ping reports whether the connection is up; use it as a health check.
+func (m *ManagedConnection) Ping(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.state == StateConnected && !m.conn.IsClosed() {
+		return nil
+	}
+	if m.lastErr != nil {
+		return fmt.Errorf("rabbitmq %s: %v", m.state, m.lastErr)
+	}
+	return fmt.Errorf("rabbitmq %s", m.state)
+}
+
+// Channel opens a channel on the current connection, or returns
+// ErrNotConnected while reconnecting. Close the channel when done.
+func (m *ManagedConnection) Channel() (*amqp.Channel, error) {
+	m.mu.RLock()
+	conn, state := m.conn, m.state
+	m.mu.RUnlock()
+
+	if state != StateConnected {
+		return nil, fmt.Errorf("%w: %s", ErrNotConnected, state)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a channel: %w", err)
+	}
+	return ch, nil
+}
+
+// WaitConnected blocks until the connection is up, ctx ends or the
+// connection is closed.
+func (m *ManagedConnection) WaitConnected(ctx context.Context) error {
+	m.mu.RLock()
+	connected := m.connected
+	m.mu.RUnlock()
+
+	select {
+	case <-connected:
+		return nil
+	case <-m.done:
+		return fmt.Errorf("%w: %s", ErrNotConnected, StateClosed)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consume consumes queue like ConsumeContext on a channel with the
+// Prefetch QoS, and subscribes again whenever the channel or connection is
+// lost. It returns nil once ctx is cancelled and an error only when the
+// connection was closed.
+func (m *ManagedConnection) Consume(ctx context.Context, queueName string, handler Handler) error {
+	backoff := time.Second
+	for {
+		if err := m.WaitConnected(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		started := time.Now()
+		err := m.consumeOnce(ctx, queueName, handler)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if time.Since(started) > m.MaxBackoff {
+			backoff = time.Second // the consumer was up for a while, not flapping
+		}
+		common.Warn("Consumer for queue '%s' interrupted, resubscribing in %v: %v", queueName, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, m.MaxBackoff)
+	}
+}
+
+func (m *ManagedConnection) consumeOnce(ctx context.Context, queueName string, handler Handler) error {
+	ch, err := m.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if m.Prefetch > 0 {
+		if err := ch.Qos(m.Prefetch, 0, false); err != nil {
+			return fmt.Errorf("failed to set QoS: %w", err)
+		}
+	}
+	return ConsumeContext(ctx, ch, queueName, handler)
+}
+
+// Close stops reconnecting and closes the connection.
+func (m *ManagedConnection) Close() error {
+	m.mu.Lock()
+	if m.state == StateClosed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.state = StateClosed
+	close(m.done)
+	conn := m.conn
+	m.mu.Unlock()
+
+	if conn != nil && !conn.IsClosed() {
+		return conn.Close()
+	}
+	return nil
+}
+
+// watch waits for conn to close and replaces it, until Close is called.
+func (m *ManagedConnection) watch(conn *amqp.Connection) {
+	for {
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		var reason error
+		select {
+		case amqpErr := <-closed:
+			// A nil error means a clean close: ours, or one that happened
+			// before NotifyClose was registered
+			if amqpErr != nil {
+				reason = amqpErr
+			} else {
+				reason = amqp.ErrClosed
+			}
+		case <-m.done:
+			return
+		}
+
+		common.Warn("RabbitMQ connection lost: %v", reason)
+		if !m.setDisconnected(reason) {
+			return
+		}
+
+		conn = m.reconnect(time.Second)
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials after backoff, and with growing delays until it succeeds
+// or Close is called, then returns the new connection, or nil when closed.
+func (m *ManagedConnection) reconnect(backoff time.Duration) *amqp.Connection {
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-m.done:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		conn, err := amqp.Dial(m.cfg.Host)
+		if err == nil {
+			err = m.runSetup(conn)
+			if err != nil {
+				conn.Close()
+			}
+		}
+		if err == nil {
+			first := m.State() == StateConnecting
+			if !m.setConnected(conn) {
+				conn.Close()
+				return nil
+			}
+			if first {
+				common.Ok("Connected to RabbitMQ after %d attempt(s)", attempt)
+			} else {
+				common.Ok("Reconnected to RabbitMQ after %d attempt(s)", attempt)
+			}
+			return conn
+		}
+
+		backoff = min(max(backoff*2, time.Second), m.MaxBackoff)
+		m.mu.Lock()
+		m.lastErr = err
+		m.mu.Unlock()
+		common.Warn("Reconnect attempt %d failed, retrying in %v: %v", attempt, backoff, err)
+	}
+}
+
+func (m *ManagedConnection) runSetup(conn *amqp.Connection) error {
+	for _, setup := range m.setup {
+		if err := setup(conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setConnected installs conn and wakes up waiting consumers. It reports
+// false if the connection was closed in the meantime.
+func (m *ManagedConnection) setConnected(conn *amqp.Connection) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == StateClosed {
+		return false
+	}
+	m.conn = conn
+	m.state = StateConnected
+	m.lastErr = nil
+	close(m.connected)
+	return true
+}
+
+// setDisconnected marks the connection as lost. It reports false if the
+// connection was closed in the meantime.
+func (m *ManagedConnection) setDisconnected(reason error) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == StateClosed {
+		return false
+	}
+	m.state = StateReconnecting
+	m.lastErr = reason
+	m.connected = make(chan struct{})
+	return true
+}
@@ -1,23 +1,51 @@
 package rabbitmq
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/nesiler/cestx/common"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrNacked is returned when the broker refuses a confirmed message.
+	ErrNacked = errors.New("message nacked by broker")
+	// ErrUnroutable is returned when a mandatory message matches no queue.
+	ErrUnroutable = errors.New("message unroutable")
+	// ErrConfirmTimeout is returned when the broker does not confirm in time.
+	ErrConfirmTimeout = errors.New("timed out waiting for publisher confirm")
 )
 
 // Publish publishes a message to the specified exchange and routing key.
 // It handles common publishing tasks and error scenarios.
 func Publish(ch *amqp.Channel, exchange, routingKey string, message interface{}) error {
+	return PublishContext(context.Background(), ch, exchange, routingKey, message)
+}
+
+// PublishContext publishes like Publish and carries the trace context of
+// ctx in the message headers, so the consumer continues the same trace.
+// It does not wait for the broker; use a Publisher when the message must
+// not be lost.
+func PublishContext(ctx context.Context, ch *amqp.Channel, exchange, routingKey string, message interface{}) (err error) {
 	// Input validation
 	if ch == nil {
 		return common.Err("Channel is required")
 	}
 
-	body, err := json.Marshal(message)
+	ctx, span := startPublishSpan(ctx, exchange, routingKey)
+	defer func() { endSpan(span, err) }()
+
+	msg, err := newPublishing(ctx, message)
 	if err != nil {
-		return common.Err("Failed to marshal message: %v", err)
+		return err
 	}
 
 	// Publish the message
@@ -26,10 +54,7 @@ func Publish(ch *amqp.Channel, exchange, routingKey string, message interface{})
 		routingKey, // routing key
 		false,      // mandatory
 		false,      // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
+		msg,
 	)
 	if err != nil {
 		return common.Err("Failed to publish message: %v", err)
@@ -38,3 +63,212 @@ func Publish(ch *amqp.Channel, exchange, routingKey string, message interface{})
 	common.Ok("Message published successfully to exchange '%s' with routing key '%s'", exchange, routingKey)
 	return nil
 }
+
+// Publisher publishes on a channel in confirm mode: every message is
+// persistent and mandatory, and Publish returns only once the broker has
+// taken responsibility for it. Publish may be called from several
+// goroutines; it publishes one message at a time.
+type Publisher struct {
+	// Timeout bounds the wait for the broker's confirm.
+	Timeout time.Duration
+
+	ch   *amqp.Channel
+	done chan struct{} // closed when the channel closes
+
+	mu  sync.Mutex // serializes Publish
+	tag uint64     // delivery tag of the last published message
+
+	// The message waiting for its confirm, guarded by state
+	state    sync.Mutex
+	waitTag  uint64
+	waitID   string
+	returned string // reply text if the message was returned as unroutable
+	result   chan error
+}
+
+// NewPublisher puts ch in confirm mode. Use the channel for nothing else.
+func NewPublisher(ch *amqp.Channel) (*Publisher, error) {
+	if ch == nil {
+		return nil, common.Err("Channel is required")
+	}
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to put channel in confirm mode: %w", err)
+	}
+
+	p := &Publisher{
+		Timeout: 5 * time.Second,
+		ch:      ch,
+		done:    make(chan struct{}),
+	}
+	// The library blocks the whole connection while a notification is not
+	// read, so confirms and returns are consumed at all times
+	go p.listen(ch.NotifyPublish(make(chan amqp.Confirmation, 1)), ch.NotifyReturn(make(chan amqp.Return, 1)))
+	return p, nil
+}
+
+// Publish publishes a persistent, mandatory message and waits for the
+// broker's confirm. It fails with ErrUnroutable when no queue is bound for
+// the routing key, ErrNacked when the broker refuses the message and
+// ErrConfirmTimeout when it does not answer within Timeout or ctx.
+func (p *Publisher) Publish(ctx context.Context, exchange, routingKey string, message interface{}) (err error) {
+	ctx, span := startPublishSpan(ctx, exchange, routingKey)
+	defer func() { endSpan(span, err) }()
+
+	msg, err := newPublishing(ctx, message)
+	if err != nil {
+		return err
+	}
+	return p.publish(ctx, exchange, routingKey, msg)
+}
+
+// PublishMessage publishes msg as it is, e.g. a copy of a delivery, and
+// waits for the broker's confirm like Publish. The message is mandatory;
+// its delivery mode and headers are kept.
+func (p *Publisher) PublishMessage(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) (err error) {
+	ctx, span := startPublishSpan(ctx, exchange, routingKey)
+	defer func() { endSpan(span, err) }()
+
+	return p.publish(ctx, exchange, routingKey, msg)
+}
+
+func (p *Publisher) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Wait for the next tag before publishing, the confirm may be quick
+	result := make(chan error, 1)
+	p.wait(p.tag+1, msg.MessageId, result)
+	defer p.wait(0, "", nil)
+
+	if err := p.ch.Publish(exchange, routingKey, true, false, msg); err != nil {
+		return common.Err("Failed to publish message: %v", err)
+	}
+	p.tag++
+
+	timeout := time.NewTimer(p.Timeout)
+	defer timeout.Stop()
+	select {
+	case err := <-result:
+		if err != nil {
+			return common.Err("Message %s to exchange '%s' with routing key '%s': %w", msg.MessageId, exchange, routingKey, err)
+		}
+		common.Ok("Message %s confirmed on exchange '%s' with routing key '%s'", msg.MessageId, exchange, routingKey)
+		return nil
+	case <-p.done:
+		return common.Err("Channel closed before message %s was confirmed", msg.MessageId)
+	case <-timeout.C:
+		return common.Err("Message %s to '%s': %w after %v", msg.MessageId, routingKey, ErrConfirmTimeout, p.Timeout)
+	case <-ctx.Done():
+		return common.Err("Message %s to '%s': %w: %v", msg.MessageId, routingKey, ErrConfirmTimeout, ctx.Err())
+	}
+}
+
+func (p *Publisher) wait(tag uint64, messageID string, result chan error) {
+	p.state.Lock()
+	defer p.state.Unlock()
+	p.waitTag, p.waitID, p.returned, p.result = tag, messageID, "", result
+}
+
+// listen settles the waiting message until the channel closes.
+func (p *Publisher) listen(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	defer close(p.done)
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				return
+			}
+			p.recordReturn(ret)
+		case confirm, ok := <-confirms:
+			if !ok {
+				return
+			}
+			// The broker sends basic.return before the confirm of the same
+			// message, so a return for it is already buffered
+			select {
+			case ret, ok := <-returns:
+				if ok {
+					p.recordReturn(ret)
+				}
+			default:
+			}
+			p.settle(confirm)
+		}
+	}
+}
+
+func (p *Publisher) recordReturn(ret amqp.Return) {
+	p.state.Lock()
+	defer p.state.Unlock()
+	if ret.MessageId == p.waitID {
+		p.returned = ret.ReplyText
+	}
+}
+
+// settle reports the confirm to the waiting Publish; confirms of messages
+// that timed out are dropped.
+func (p *Publisher) settle(confirm amqp.Confirmation) {
+	p.state.Lock()
+	defer p.state.Unlock()
+	if p.result == nil || confirm.DeliveryTag != p.waitTag {
+		return
+	}
+
+	var err error
+	switch {
+	case !confirm.Ack:
+		err = ErrNacked
+	case p.returned != "":
+		err = fmt.Errorf("%w: %s", ErrUnroutable, p.returned)
+	}
+	p.result <- err
+	p.result = nil
+}
+
+// PublishConfirmed publishes like Publisher.Publish on a channel of its own,
+// for the occasional message that must not be lost.
+func (m *ManagedConnection) PublishConfirmed(ctx context.Context, exchange, routingKey string, message interface{}) error {
+	ch, err := m.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	publisher, err := NewPublisher(ch)
+	if err != nil {
+		return err
+	}
+	return publisher.Publish(ctx, exchange, routingKey, message)
+}
+
+// newPublishing marshals message into a persistent JSON message with a
+// fresh message ID, a timestamp and the trace context of ctx.
+func newPublishing(ctx context.Context, message interface{}) (amqp.Publishing, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return amqp.Publishing{}, common.Err("Failed to marshal message: %v", err)
+	}
+
+	headers := amqp.Table{}
+	injectTrace(ctx, headers)
+
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    uuid.NewString(),
+		Timestamp:    time.Now().UTC(),
+		Body:         body,
+	}, nil
+}
+
+func startPublishSpan(ctx context.Context, exchange, routingKey string) (context.Context, trace.Span) {
+	return common.Tracer().Start(ctx, fmt.Sprintf("%s publish", routingKey),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+		),
+	)
+}
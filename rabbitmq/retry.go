@@ -0,0 +1,175 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/streadway/amqp"
+)
+
+// A queue with a RetryPolicy never requeues a failed message in place.
+// For a queue named machine.create the topology adds:
+//
+//	machine.create.retry.1 .. .N  TTL queues, one per delay, that dead-letter
+//	                              back into machine.create when the TTL ends
+//	machine.create.dlx            the queue's dead-letter exchange (fanout)
+//	machine.create.dlq            parked messages, bound to machine.create.dlx
+//
+// A failed message is republished to the next retry queue with the attempt
+// count in the x-retry-count header, and acked once the broker confirmed
+// the copy. Once the policy's attempts are used
+// up, or the handler returned a Permanent error, the message is published
+// to the dead-letter exchange with the last error in x-last-error and so
+// parked in the .dlq queue. Parked messages are inspected and replayed with
+// Inspect and Replay, or the dlq command.
+//
+// The consumer moves messages itself rather than relying on a
+// x-dead-letter-exchange argument on machine.create, since arguments of an
+// existing queue cannot change and the service queues predate retries.
+
+const (
+	HeaderRetryCount    = "x-retry-count"    // failed attempts so far
+	HeaderLastError     = "x-last-error"     // error of the last attempt
+	HeaderOriginalQueue = "x-original-queue" // queue a parked message came from
+)
+
+// RetryPolicy sets how often and how late failed messages are retried.
+type RetryPolicy struct {
+	// Delays holds the delay before each retry; a message is handled at
+	// most len(Delays)+1 times.
+	Delays []time.Duration
+}
+
+// ExponentialRetry retries up to retries times, waiting first, then
+// twice as long before every next retry.
+func ExponentialRetry(first time.Duration, retries int) *RetryPolicy {
+	p := &RetryPolicy{}
+	for delay := first; len(p.Delays) < retries; delay *= 2 {
+		p.Delays = append(p.Delays, delay)
+	}
+	return p
+}
+
+// DefaultRetry retries after 5s, 10s, 20s and 40s before parking a message.
+var DefaultRetry = ExponentialRetry(5*time.Second, 4)
+
+// MaxAttempts is the number of times a message is handled before it is parked.
+func (p *RetryPolicy) MaxAttempts() int {
+	return len(p.Delays) + 1
+}
+
+// RetryQueueName is the TTL queue holding messages before retry attempt n (1-based).
+func RetryQueueName(queue string, n int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, n)
+}
+
+// DeadLetterExchangeName is the dead-letter exchange of queue.
+func DeadLetterExchangeName(queue string) string {
+	return queue + ".dlx"
+}
+
+// DeadLetterQueueName is the queue parked messages of queue end up in.
+func DeadLetterQueueName(queue string) string {
+	return queue + ".dlq"
+}
+
+// permanentError marks an error that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the message is parked right away instead of
+// retried, e.g. for malformed JSON.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// RetryCount returns the number of failed attempts recorded on a delivery.
+func RetryCount(d amqp.Delivery) int {
+	switch n := d.Headers[HeaderRetryCount].(type) {
+	case int:
+		return n
+	case int16:
+		return int(n)
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	}
+	return 0
+}
+
+// fail settles a delivery the handler failed on, following the queue's
+// retry policy. Queues without one requeue the message, as before.
+func fail(ctx context.Context, publisher *Publisher, q Queue, d amqp.Delivery, handlerErr error) {
+	if q.Retry == nil {
+		if err := d.Nack(false, true); err != nil {
+			common.Err("Failed to Nack the message: %v", err)
+		}
+		return
+	}
+
+	attempt := RetryCount(d) + 1
+	msg := republish(d)
+	msg.Headers[HeaderRetryCount] = int32(attempt)
+	msg.Headers[HeaderLastError] = handlerErr.Error()
+
+	var exchange, key string
+	if attempt < q.Retry.MaxAttempts() && !IsPermanent(handlerErr) {
+		exchange, key = "", RetryQueueName(q.Name, attempt)
+		common.Warn("Message on '%s' failed (attempt %d/%d), retrying in %v", q.Name, attempt, q.Retry.MaxAttempts(), q.Retry.Delays[attempt-1])
+	} else {
+		msg.Headers[HeaderOriginalQueue] = q.Name
+		exchange, key = DeadLetterExchangeName(q.Name), ""
+		common.Err("Message on '%s' failed after %d attempt(s), parking it in '%s'", q.Name, attempt, DeadLetterQueueName(q.Name))
+	}
+
+	if err := publisher.PublishMessage(ctx, exchange, key, msg); err != nil {
+		// Keep the message rather than lose it; it is redelivered
+		common.Err("Failed to republish the message, requeueing it: %v", err)
+		if err := d.Nack(false, true); err != nil {
+			common.Err("Failed to Nack the message: %v", err)
+		}
+		return
+	}
+	if err := d.Ack(false); err != nil {
+		common.Err("Failed to acknowledge message: %v", err)
+	}
+}
+
+// republish copies a delivery into a new message, headers included.
+func republish(d amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
@@ -0,0 +1,288 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nesiler/cestx/common"
+	"github.com/streadway/amqp"
+)
+
+// Request/reply runs over the existing queues. The caller publishes a
+// request with ReplyTo set to RabbitMQ's direct reply-to pseudo-queue and a
+// fresh CorrelationId; a handler wrapped with RPC answers on ReplyTo with an
+// RPCReply carrying the same CorrelationId. Messages without ReplyTo are
+// plain events and keep the queue's retry policy.
+//
+//	var result rabbitmq.MachineResult
+//	err := amqpConn.Call(ctx, rabbitmq.ExchangeMachines, rabbitmq.QueueMachineCreate, msg, &result)
+//	if errors.Is(err, common.ErrNotFound) { ... }
+
+// directReplyTo is the pseudo-queue replies are consumed from; it needs no
+// declaration and the consumer must run on the publishing channel.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// HeaderDeadline holds the caller's deadline in Unix milliseconds. Requests
+// past it are dropped unhandled, and handlers get it as their ctx deadline.
+// A message TTL would do the former, but would also park expired requests
+// in the dead-letter queue.
+const HeaderDeadline = "x-deadline"
+
+// Error codes of an RPCError.
+const (
+	RPCNotFound = "not_found" // the handler failed with common.ErrNotFound
+	RPCInvalid  = "invalid"   // the handler failed with a Permanent error
+	RPCInternal = "internal"  // any other failure
+)
+
+// RPCReply is the body of every reply: either Result or Error is set.
+type RPCReply struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is an error returned by a remote handler.
+type RPCError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("remote %s error: %s", e.Code, e.Message)
+}
+
+// Unwrap lets callers test remote failures with errors.Is(err, common.ErrNotFound).
+func (e *RPCError) Unwrap() error {
+	if e.Code == RPCNotFound {
+		return common.ErrNotFound
+	}
+	return nil
+}
+
+func newRPCError(err error) *RPCError {
+	code := RPCInternal
+	switch {
+	case errors.Is(err, common.ErrNotFound):
+		code = RPCNotFound
+	case IsPermanent(err):
+		code = RPCInvalid
+	}
+	return &RPCError{Code: code, Message: err.Error()}
+}
+
+// RPCHandler handles a request and returns the result to reply with.
+type RPCHandler func(ctx context.Context, d amqp.Delivery) (interface{}, error)
+
+// publisherKey carries the consumer's Publisher in the handler context, so
+// replies go out on the channel the request came in on.
+type publisherKey struct{}
+
+// RPC adapts an RPCHandler to a Handler for Consume. Requests are always
+// acknowledged once the result or error has been sent back; retrying is up
+// to the caller. Messages without ReplyTo are handled as events.
+func RPC(handler RPCHandler) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		if d.ReplyTo == "" {
+			_, err := handler(ctx, d)
+			return err
+		}
+
+		if ms, ok := d.Headers[HeaderDeadline].(int64); ok {
+			deadline := time.UnixMilli(ms)
+			if time.Now().After(deadline) {
+				common.Warn("Dropping request %s, its caller gave up %v ago", d.CorrelationId, time.Since(deadline).Round(time.Millisecond))
+				return nil
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+
+		result, err := handler(ctx, d)
+		if err != nil {
+			common.Err("Request %s failed: %v", d.CorrelationId, err)
+		}
+
+		reply := RPCReply{}
+		if err != nil {
+			reply.Error = newRPCError(err)
+		} else if reply.Result, err = json.Marshal(result); err != nil {
+			reply.Error = newRPCError(fmt.Errorf("failed to marshal result: %w", err))
+		}
+
+		publisher, _ := ctx.Value(publisherKey{}).(*Publisher)
+		if publisher == nil {
+			return Permanent(fmt.Errorf("no channel to reply to request %s on", d.CorrelationId))
+		}
+		msg, err := newPublishing(ctx, reply)
+		if err != nil {
+			return err
+		}
+		msg.CorrelationId = d.CorrelationId
+		msg.DeliveryMode = amqp.Transient
+		if err := publisher.PublishMessage(ctx, "", d.ReplyTo, msg); err != nil {
+			// The caller times out; redelivering would run the request twice
+			common.Err("Failed to reply to request %s: %v", d.CorrelationId, err)
+		}
+		return nil
+	}
+}
+
+// RPCClient sends requests and waits for their replies on one channel.
+// It is safe for concurrent use. Like the channel, it does not survive a
+// reconnect; ManagedConnection.Call uses a fresh one per call.
+type RPCClient struct {
+	// Timeout bounds calls whose context has no deadline.
+	Timeout time.Duration
+
+	ch   *amqp.Channel
+	done chan struct{} // closed when the channel closes
+
+	mu      sync.Mutex
+	pending map[string]chan rpcResponse
+}
+
+type rpcResponse struct {
+	delivery amqp.Delivery
+	err      error
+}
+
+// NewRPCClient starts consuming replies on ch. Use the channel for nothing else.
+func NewRPCClient(ch *amqp.Channel) (*RPCClient, error) {
+	if ch == nil {
+		return nil, common.Err("Channel is required")
+	}
+
+	// Direct reply-to requires no-ack consumption
+	replies, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume replies: %w", err)
+	}
+
+	c := &RPCClient{
+		Timeout: 30 * time.Second,
+		ch:      ch,
+		done:    make(chan struct{}),
+		pending: make(map[string]chan rpcResponse),
+	}
+	go c.dispatch(replies, ch.NotifyReturn(make(chan amqp.Return, 1)))
+	return c, nil
+}
+
+// Call publishes request to exchange with routingKey and decodes the
+// reply's result into result, which may be nil. It fails with the remote
+// *RPCError, with ErrUnroutable when no queue takes the request, or with
+// the context's error when no reply arrives before its deadline or Timeout.
+// Handlers drop the request once the deadline has passed.
+func (c *RPCClient) Call(ctx context.Context, exchange, routingKey string, request, result interface{}) (err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	ctx, span := startPublishSpan(ctx, exchange, routingKey)
+	defer func() { endSpan(span, err) }()
+
+	msg, err := newPublishing(ctx, request)
+	if err != nil {
+		return err
+	}
+	msg.CorrelationId = uuid.NewString()
+	msg.ReplyTo = directReplyTo
+	msg.DeliveryMode = amqp.Transient
+	msg.Headers[HeaderDeadline] = deadline.UnixMilli()
+
+	response := make(chan rpcResponse, 1)
+	c.mu.Lock()
+	c.pending[msg.CorrelationId] = response
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, msg.CorrelationId)
+		c.mu.Unlock()
+	}()
+
+	if err := c.ch.Publish(exchange, routingKey, true, false, msg); err != nil {
+		return common.Err("Failed to publish request: %v", err)
+	}
+
+	var reply RPCReply
+	select {
+	case res := <-response:
+		if res.err != nil {
+			return fmt.Errorf("request to exchange '%s' with routing key '%s': %w", exchange, routingKey, res.err)
+		}
+		if err := json.Unmarshal(res.delivery.Body, &reply); err != nil {
+			return fmt.Errorf("invalid reply to request %s: %w", msg.CorrelationId, err)
+		}
+	case <-c.done:
+		return common.Err("Channel closed before request %s was answered", msg.CorrelationId)
+	case <-ctx.Done():
+		return fmt.Errorf("no reply to request %s on '%s': %w", msg.CorrelationId, routingKey, ctx.Err())
+	}
+
+	if reply.Error != nil {
+		return reply.Error
+	}
+	if result != nil && len(reply.Result) > 0 {
+		if err := json.Unmarshal(reply.Result, result); err != nil {
+			return fmt.Errorf("invalid result of request %s: %w", msg.CorrelationId, err)
+		}
+	}
+	return nil
+}
+
+// dispatch hands replies and returned requests to the waiting calls until
+// the channel closes.
+func (c *RPCClient) dispatch(replies <-chan amqp.Delivery, returns <-chan amqp.Return) {
+	defer close(c.done)
+	for {
+		select {
+		case d, ok := <-replies:
+			if !ok {
+				return
+			}
+			c.respond(d.CorrelationId, rpcResponse{delivery: d})
+		case ret, ok := <-returns:
+			if !ok {
+				return
+			}
+			c.respond(ret.CorrelationId, rpcResponse{err: fmt.Errorf("%w: %s", ErrUnroutable, ret.ReplyText)})
+		}
+	}
+}
+
+func (c *RPCClient) respond(correlationID string, res rpcResponse) {
+	c.mu.Lock()
+	response, ok := c.pending[correlationID]
+	delete(c.pending, correlationID)
+	c.mu.Unlock()
+
+	if !ok {
+		common.Warn("Dropping reply to unknown or expired request %s", correlationID)
+		return
+	}
+	response <- res
+}
+
+// Call sends a request like RPCClient.Call on a channel of its own.
+func (m *ManagedConnection) Call(ctx context.Context, exchange, routingKey string, request, result interface{}) error {
+	ch, err := m.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	client, err := NewRPCClient(ch)
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, exchange, routingKey, request, result)
+}
@@ -0,0 +1,184 @@
+package rabbitmq
+
+import (
+	"fmt"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/streadway/amqp"
+)
+
+// Exchange describes an exchange to declare.
+type Exchange struct {
+	Name string
+	Kind string // direct, fanout, topic or headers
+	Args amqp.Table
+}
+
+// Binding routes messages published to Exchange with a matching RoutingKey to a queue.
+type Binding struct {
+	Exchange   string
+	RoutingKey string
+}
+
+// Queue describes a queue to declare and the bindings that feed it.
+type Queue struct {
+	Name     string
+	Args     amqp.Table
+	Bindings []Binding
+	// Retry adds delayed retries and a dead-letter queue, see RetryPolicy.
+	// Without one, failed messages are requeued in place.
+	Retry *RetryPolicy
+}
+
+// Topology is the set of exchanges and queues the services rely on.
+// Everything is durable so it survives a broker restart.
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+}
+
+// DefaultTopology is the single source of truth for the exchanges, queues
+// and bindings in constants.go. Every queue is bound to its service's
+// exchange with its own name as routing key, so
+// Publish(ch, ExchangeDynoxy, QueueDynoxyCreate, msg) lands in dynoxy.create.
+var DefaultTopology = Topology{
+	Exchanges: []Exchange{
+		{Name: ExchangeMachines, Kind: amqp.ExchangeTopic},
+		{Name: ExchangeLogger, Kind: amqp.ExchangeTopic},
+		{Name: ExchangeDynoxy, Kind: amqp.ExchangeTopic},
+		{Name: ExchangeTaskmaster, Kind: amqp.ExchangeTopic},
+		{Name: ExchangeTemplate, Kind: amqp.ExchangeTopic},
+		{Name: ExchangeRegistry, Kind: amqp.ExchangeTopic},
+	},
+	Queues: []Queue{
+		serviceQueue(ExchangeMachines, QueueMachineCreate),
+		serviceQueue(ExchangeMachines, QueueMachineDelete),
+		serviceQueue(ExchangeMachines, QueueMachineStart),
+		serviceQueue(ExchangeMachines, QueueMachineStop),
+		serviceQueue(ExchangeMachines, QueueMachineUpdate),
+
+		serviceQueue(ExchangeTemplate, QueueTemplateCreate),
+		serviceQueue(ExchangeTemplate, QueueTemplateDelete),
+		serviceQueue(ExchangeTemplate, QueueTemplateUpdate),
+
+		// The logger listens to every machine and dynoxy event
+		{Name: QueueLoggerMachine, Bindings: []Binding{{Exchange: ExchangeMachines, RoutingKey: RoutingMachineAll}}, Retry: DefaultRetry},
+		{Name: QueueLoggerDynoxy, Bindings: []Binding{{Exchange: ExchangeDynoxy, RoutingKey: RoutingDynoxyAll}}, Retry: DefaultRetry},
+
+		serviceQueue(ExchangeDynoxy, QueueDynoxyCreate),
+		serviceQueue(ExchangeDynoxy, QueueDynoxyDelete),
+
+		serviceQueue(ExchangeTaskmaster, QueueTaskmasterAnsible),
+		serviceQueue(ExchangeTaskmaster, QueueTaskmasterSSH),
+		serviceQueue(ExchangeTaskmaster, QueueTaskmasterScript),
+	},
+}
+
+// serviceQueue is a queue bound to exchange under its own name, with the default retry policy.
+func serviceQueue(exchange, name string) Queue {
+	return Queue{Name: name, Bindings: []Binding{{Exchange: exchange, RoutingKey: name}}, Retry: DefaultRetry}
+}
+
+// Queue returns the declared shape of the named queue.
+// Unknown queues get a plain durable queue without bindings.
+func (t Topology) Queue(name string) Queue {
+	for _, q := range t.Queues {
+		if q.Name == name {
+			return q
+		}
+	}
+	return Queue{Name: name}
+}
+
+// Declare declares every exchange, queue and binding. Declaring is
+// idempotent, so every service can apply the topology on startup; it fails
+// only if an existing exchange or queue was declared with other settings.
+func (t Topology) Declare(ch *amqp.Channel) error {
+	for _, ex := range t.Exchanges {
+		if err := ch.ExchangeDeclare(ex.Name, ex.Kind, true, false, false, false, ex.Args); err != nil {
+			return fmt.Errorf("failed to declare exchange '%s': %w", ex.Name, err)
+		}
+	}
+
+	for _, q := range t.Queues {
+		if err := declareQueue(ch, q); err != nil {
+			return err
+		}
+		for _, b := range q.Bindings {
+			if err := ch.QueueBind(q.Name, b.RoutingKey, b.Exchange, false, nil); err != nil {
+				return fmt.Errorf("failed to bind queue '%s' to '%s' with key '%s': %w", q.Name, b.Exchange, b.RoutingKey, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// DeclareTopology applies DefaultTopology on a channel of its own, since a
+// failed declaration closes the channel it was made on.
+func DeclareTopology(conn *amqp.Connection) error {
+	if conn == nil {
+		return common.Err("Connection is nil")
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return common.Err("Failed to open a channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := DefaultTopology.Declare(ch); err != nil {
+		return common.Err("Failed to declare RabbitMQ topology: %w", err)
+	}
+
+	common.Ok("RabbitMQ topology declared")
+	return nil
+}
+
+// declareQueue declares q and, if it has a retry policy, its retry queues,
+// dead-letter exchange and dead-letter queue. q itself keeps exactly
+// q.Args: failed messages are moved by the consumer, so queues declared
+// before retries existed can be redeclared unchanged.
+func declareQueue(ch *amqp.Channel, q Queue) error {
+	if q.Retry != nil {
+		dlx, dlq := DeadLetterExchangeName(q.Name), DeadLetterQueueName(q.Name)
+		if err := ch.ExchangeDeclare(dlx, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare exchange '%s': %w", dlx, err)
+		}
+		if err := declareDurableQueue(ch, dlq, nil); err != nil {
+			return err
+		}
+		if err := ch.QueueBind(dlq, "", dlx, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue '%s' to '%s': %w", dlq, dlx, err)
+		}
+
+		// Expired messages go back to q through the default exchange
+		for i, delay := range q.Retry.Delays {
+			err := declareDurableQueue(ch, RetryQueueName(q.Name, i+1), amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": q.Name,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return declareDurableQueue(ch, q.Name, q.Args)
+}
+
+func declareDurableQueue(ch *amqp.Channel, name string, args amqp.Table) error {
+	_, err := ch.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		args,  // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare queue '%s': %w", name, err)
+	}
+	return nil
+}
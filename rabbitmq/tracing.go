@@ -0,0 +1,86 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier lets the OpenTelemetry propagator read and write the trace
+// context in AMQP message headers.
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// HeaderRequestID carries the request ID of the publishing context, so
+// the consumer logs with the same request_id as the caller.
+const HeaderRequestID = "x-request-id"
+
+// injectTrace writes the trace context and request ID of ctx into the
+// message headers.
+func injectTrace(ctx context.Context, headers amqp.Table) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+	if id := common.RequestID(ctx); id != "" {
+		headers[HeaderRequestID] = id
+	}
+}
+
+// withRequestID adds the delivery's request ID to the log fields of ctx.
+// Messages published outside a request are identified by their message ID.
+func withRequestID(ctx context.Context, d amqp.Delivery) context.Context {
+	id, _ := d.Headers[HeaderRequestID].(string)
+	if id == "" {
+		id = d.MessageId
+	}
+	if id == "" {
+		return ctx
+	}
+	return common.WithLogFields(ctx, common.FieldRequestID, id)
+}
+
+// startConsumerSpan continues the trace carried by the delivery headers.
+func startConsumerSpan(d amqp.Delivery) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if d.Headers != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(d.Headers))
+	}
+	return common.Tracer().Start(ctx, fmt.Sprintf("%s process", d.RoutingKey),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", d.Exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", d.RoutingKey),
+			attribute.String("messaging.message.id", d.MessageId),
+		),
+	)
+}
+
+// endSpan records err on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -48,6 +48,15 @@ type MachineMessage struct {
 	// TODO add more fields as needed
 }
 
+// MachineResult is the reply to a machine request sent with Call.
+type MachineResult struct {
+	MachineID uuid.UUID `json:"machine_id"`
+	Name      string    `json:"name,omitempty"`
+	Running   bool      `json:"running"`
+	URL       string    `json:"url,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
 // ------------------------------------
 // Template Events and Messages
 // ------------------------------------
@@ -70,6 +79,13 @@ type TemplateMessage struct {
 	// TODO add more fields as needed
 }
 
+// TemplateResult is the reply to a template request sent with Call.
+type TemplateResult struct {
+	TemplateID uuid.UUID `json:"template_id"`
+	Name       string    `json:"name"`
+	File       string    `json:"file,omitempty"`
+}
+
 // ------------------------------------
 // Dynoxy Events and Messages
 // ------------------------------------
@@ -115,6 +131,31 @@ type TaskmasterMessage struct {
 	// TODO: Add more fields as needed for different task types
 }
 
+// ------------------------------------
+// Registry Events and Messages
+// ------------------------------------
+
+// RegistryEvent represents a change in the service registry.
+// It doubles as the routing key on ExchangeRegistry.
+type RegistryEvent string
+
+// Constants for registry events
+const (
+	RegistryRegistered   RegistryEvent = "registry.registered"
+	RegistryDeregistered RegistryEvent = "registry.deregistered"
+	RegistryStatus       RegistryEvent = "registry.status"
+)
+
+// RegistryMessage represents a registry event
+type RegistryMessage struct {
+	Event          RegistryEvent `json:"event"`
+	ServiceID      string        `json:"service_id"`
+	Name           string        `json:"name,omitempty"`
+	Status         string        `json:"status,omitempty"`
+	PreviousStatus string        `json:"previous_status,omitempty"`
+	Timestamp      time.Time     `json:"timestamp"`
+}
+
 // ------------------------------------
 // Logger Service Message
 // ------------------------------------
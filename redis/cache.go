@@ -32,7 +32,7 @@ func Get(ctx context.Context, rdb *redis.Client, key string, target interface{})
 	val, err := rdb.Get(ctx, key).Result()
 	if err != nil {
 		if err == redis.Nil {
-			return common.Err("Key '%s' not found in Redis", key)
+			return common.Err("Key '%s' %w in Redis", key, common.ErrNotFound)
 		}
 		return common.Err("Failed to get value from Redis: %v", err)
 	}
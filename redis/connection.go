@@ -44,3 +44,11 @@ func Close(rdb *redis.Client) error {
 
 	return nil
 }
+
+// Ping checks that the Redis server answers; use it as a health check.
+func Ping(ctx context.Context, rdb *redis.Client) error {
+	if rdb == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	return rdb.Ping(ctx).Err()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/nesiler/cestx/common"
+)
+
+// serviceACL lists what a service may read from the registry.
+// Configs holds backend names ("postgresql") and namespaced keys
+// ("machine-s/limits" or "machine-s/*"). Admins may read and write
+// everything. Secret, or the variable named by SecretEnv, replaces the
+// shared REGISTRY_SECRET for that service. Admins must have a secret of
+// their own, so the shared secret cannot act as an admin.
+type serviceACL struct {
+	Configs   []string `json:"configs"`
+	Admin     bool     `json:"admin,omitempty"`
+	Secret    string   `json:"secret,omitempty"`
+	SecretEnv string   `json:"secret_env,omitempty"`
+}
+
+type aclFile struct {
+	Services map[string]serviceACL `json:"services"`
+}
+
+var (
+	registrySecret string
+	acl            map[string]serviceACL
+	signingEnabled bool
+)
+
+// loadAuth reads the shared secret and the ACL file.
+// Without any secret the registry accepts unsigned requests, as before;
+// once a secret is configured, every request must be signed.
+func loadAuth() {
+	registrySecret = common.GetEnv("REGISTRY_SECRET", "")
+	if registrySecret == "" {
+		common.Warn("REGISTRY_SECRET not set, registry requests are not authenticated")
+	}
+
+	path := common.GetEnv("ACL_FILE", "acl.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		common.Warn("No ACL file at %s, config access is not restricted: %v", path, err)
+		signingEnabled = registrySecret != ""
+		return
+	}
+
+	var file aclFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		common.Fatal("Failed to parse ACL file %s: %v", path, err)
+	}
+
+	signingEnabled = registrySecret != ""
+	for id, entry := range file.Services {
+		if entry.SecretEnv != "" {
+			entry.Secret = common.GetEnv(entry.SecretEnv, "")
+			file.Services[id] = entry
+		}
+		if entry.Secret != "" {
+			signingEnabled = true
+		}
+		switch {
+		case entry.Secret == "" && entry.Admin:
+			common.Fatal("ACL entry %q is an admin but has no secret of its own; set secret or secret_env", id)
+		case entry.Admin && entry.Secret == registrySecret:
+			common.Fatal("ACL entry %q is an admin and must not use the shared REGISTRY_SECRET", id)
+		case entry.Secret == "" && registrySecret == "":
+			common.Warn("ACL entry %q has no secret and REGISTRY_SECRET is not set, its requests will be rejected", id)
+		case entry.Secret == "":
+			common.Warn("ACL entry %q has no secret of its own and is signed with the shared REGISTRY_SECRET", id)
+		}
+	}
+	acl = file.Services
+	common.Info("Loaded ACL for %d services", len(acl))
+}
+
+// lookupACL finds the ACL entry of a service ID and the ID it is listed
+// under. Instance IDs such as "machine-s-host1" fall back to the entry of
+// their base ID "machine-s".
+func lookupACL(serviceID string) (string, serviceACL, bool) {
+	if entry, ok := acl[serviceID]; ok {
+		return serviceID, entry, true
+	}
+
+	var best string
+	for id := range acl {
+		if strings.HasPrefix(serviceID, id+"-") && len(id) > len(best) {
+			best = id
+		}
+	}
+	if best == "" {
+		return "", serviceACL{}, false
+	}
+	return best, acl[best], true
+}
+
+// secretFor returns the key the requests of a service are signed with.
+// Admin entries always have their own secret, see loadAuth.
+func secretFor(serviceID string) string {
+	if _, entry, ok := lookupACL(serviceID); ok && entry.Secret != "" {
+		return entry.Secret
+	}
+	return registrySecret
+}
+
+// configReadAllowed reports whether a service may read a config.
+// Backends are listed by name in the ACL; a service may always read its
+// own namespace, which is exactly its ID or the ID of its ACL entry.
+func configReadAllowed(serviceID, namespace, key string) bool {
+	if acl == nil {
+		return true
+	}
+	if serviceID != "" && namespace == serviceID {
+		return true
+	}
+
+	id, entry, ok := lookupACL(serviceID)
+	if !ok {
+		return false
+	}
+	if entry.Admin || namespace == id {
+		return true
+	}
+
+	for _, allowed := range entry.Configs {
+		if namespace == backendsNamespace && allowed == key {
+			return true
+		}
+		if allowed == namespace+"/"+key || allowed == namespace+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// configWriteAllowed reports whether a service may write to the config store.
+func configWriteAllowed(serviceID string) bool {
+	if acl == nil {
+		return true
+	}
+	_, entry, ok := lookupACL(serviceID)
+	return ok && entry.Admin
+}
+
+// requireSignature verifies the signature of a request before passing it on.
+// The body is read once for the check and handed to the next handler unchanged.
+func requireSignature(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serviceID := r.Header.Get(common.HeaderServiceID)
+		if !signingEnabled {
+			next(w, r)
+			return
+		}
+		secret := secretFor(serviceID)
+		if secret == "" {
+			common.Warn("Rejected unsigned %s %s from %q: no secret for caller", r.Method, r.URL.Path, serviceID)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+
+		if err := common.VerifySignature(r, secret, body); err != nil {
+			common.Warn("Rejected %s %s from %q: %v", r.Method, r.URL.Path, serviceID, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// authorizedAs reports whether a verified request acts on behalf of serviceID.
+// It always holds when authentication is disabled.
+func authorizedAs(r *http.Request, serviceID string) bool {
+	if !signingEnabled {
+		return true
+	}
+	return r.Header.Get(common.HeaderServiceID) == serviceID
+}
+
+// callerIsAdmin reports whether a verified request comes from an ACL admin.
+func callerIsAdmin(r *http.Request) bool {
+	if !signingEnabled {
+		return true
+	}
+	_, entry, ok := lookupACL(r.Header.Get(common.HeaderServiceID))
+	return ok && entry.Admin
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/redis/go-redis/v9"
+)
+
+// The config store keeps every version of a value in one Redis hash,
+// config:{namespace}:{key}, with the latest version number in the
+// "version" field and each version under "v{n}".
+//
+// The "backends" namespace holds the shared backend configs. When a
+// backend has not been stored, it is built from the registry's own
+// environment as before, so /config/{type} keeps working.
+
+const backendsNamespace = "backends"
+
+// storedConfig is what is kept per version; the version is the field name.
+type storedConfig struct {
+	Value     json.RawMessage `json:"value"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	UpdatedBy string          `json:"updatedBy,omitempty"`
+}
+
+// putConfigScript bumps the version and stores the value atomically.
+// ARGV[1] is the expected current version, or -1 to skip the check.
+var putConfigScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+local expected = tonumber(ARGV[1])
+if expected >= 0 and current ~= expected then
+	return -1
+end
+local next = current + 1
+redis.call('HSET', KEYS[1], 'version', next, 'v' .. next, ARGV[2])
+return next
+`)
+
+// configKey returns the Redis hash of a config. configHandler rejects ':'
+// in namespaces and keys, so namespace a:b with key c and namespace a
+// with key b:c cannot share a hash.
+func configKey(namespace, key string) string {
+	return "config:" + namespace + ":" + key
+}
+
+// validConfigName reports whether s can be used as a namespace or key.
+func validConfigName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/:")
+}
+
+// builtinConfig builds a backend config from the registry environment.
+// A backend the registry itself is not configured for is reported as missing.
+func builtinConfig(key string) (interface{}, bool) {
+	var cfg common.BackendConfig
+	switch key {
+	case "postgresql":
+		cfg = &common.PostgreSQLConfig{}
+	case "rabbitmq":
+		cfg = &common.RabbitMQConfig{}
+	case "redis":
+		cfg = &common.RedisConfig{}
+	case "minio":
+		cfg = &common.MinIOConfig{}
+	default:
+		return nil, false
+	}
+
+	if err := common.LoadConfig(cfg); err != nil {
+		common.Warn("Builtin %s config unavailable: %v", key, err)
+		return nil, false
+	}
+	return cfg, true
+}
+
+// loadConfig returns a stored config version, or the latest when version is 0.
+// Backends that were never stored fall back to the builtin config as version 0.
+func loadConfig(namespace, key string, version int64) (*common.ConfigEntry, error) {
+	entry := &common.ConfigEntry{Namespace: namespace, Key: key, Version: version}
+
+	if version == 0 {
+		latest, err := rdb.HGet(ctx, configKey(namespace, key), "version").Int64()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		entry.Version = latest
+	}
+
+	if entry.Version == 0 {
+		if namespace == backendsNamespace && version == 0 {
+			if cfg, ok := builtinConfig(key); ok {
+				value, err := json.Marshal(cfg)
+				if err != nil {
+					return nil, err
+				}
+				entry.Value = value
+				return entry, nil
+			}
+		}
+		return nil, redis.Nil
+	}
+
+	data, err := rdb.HGet(ctx, configKey(namespace, key), "v"+strconv.FormatInt(entry.Version, 10)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var stored storedConfig
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil, err
+	}
+	entry.Value = stored.Value
+	entry.UpdatedAt = stored.UpdatedAt
+	entry.UpdatedBy = stored.UpdatedBy
+
+	return entry, nil
+}
+
+// configHandler handles the config store:
+//
+//	GET /config/{namespace}/{key}[?version=N]
+//	PUT /config/{namespace}/{key}   (If-Match: <version> for compare-and-set)
+//	GET /config/{type}              (legacy, returns the bare backend config)
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path[len("/config/"):]
+	namespace, key, scoped := strings.Cut(path, "/")
+	if !scoped {
+		namespace, key = backendsNamespace, path
+	}
+	if !validConfigName(namespace) || !validConfigName(key) {
+		http.Error(w, "Invalid config path", http.StatusBadRequest)
+		return
+	}
+
+	serviceID := r.Header.Get(common.HeaderServiceID)
+
+	switch r.Method {
+	case http.MethodGet:
+		if !configReadAllowed(serviceID, namespace, key) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		getConfig(w, r, namespace, key, scoped)
+	case http.MethodPut:
+		if !scoped {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		if !configWriteAllowed(serviceID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		putConfig(w, r, namespace, key, serviceID)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func getConfig(w http.ResponseWriter, r *http.Request, namespace, key string, scoped bool) {
+	var version int64
+	if value := r.URL.Query().Get("version"); value != "" {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || v <= 0 {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		version = v
+	}
+
+	entry, err := loadConfig(namespace, key, version)
+	if err == redis.Nil {
+		http.Error(w, "Config not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		common.Err("Failed to load config %s/%s: %v", namespace, key, err)
+		http.Error(w, "Error fetching config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", strconv.FormatInt(entry.Version, 10))
+	if !scoped {
+		w.Write(entry.Value)
+		return
+	}
+	json.NewEncoder(w).Encode(entry)
+}
+
+func putConfig(w http.ResponseWriter, r *http.Request, namespace, key, serviceID string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil || !json.Valid(body) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	expected := int64(-1)
+	if value := r.Header.Get("If-Match"); value != "" {
+		v, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+		if err != nil || v < 0 {
+			http.Error(w, "Invalid If-Match version", http.StatusBadRequest)
+			return
+		}
+		expected = v
+	}
+
+	stored, err := json.Marshal(storedConfig{
+		Value:     body,
+		UpdatedAt: time.Now(),
+		UpdatedBy: serviceID,
+	})
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	version, err := putConfigScript.Run(ctx, rdb, []string{configKey(namespace, key)}, expected, stored).Int64()
+	if err != nil {
+		common.Err("Failed to store config %s/%s: %v", namespace, key, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if version < 0 {
+		http.Error(w, "Version conflict", http.StatusConflict)
+		return
+	}
+
+	common.Info("Stored config %s/%s version %d", namespace, key, version)
+
+	entry, err := loadConfig(namespace, key, version)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", strconv.FormatInt(version, 10))
+	json.NewEncoder(w).Encode(entry)
+}
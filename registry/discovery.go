@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/nesiler/cestx/common"
+)
+
+// serviceFilter selects services for GET /services.
+// Empty fields match everything; every tag and metadata pair must match.
+type serviceFilter struct {
+	Name     string
+	Status   string
+	Tags     []string
+	Metadata map[string]string
+}
+
+// newServiceFilter reads a filter from the query string:
+// ?name=Machine&status=healthy&tag=gpu&tag=eu&meta=env:prod
+func newServiceFilter(r *http.Request) serviceFilter {
+	query := r.URL.Query()
+	filter := serviceFilter{
+		Name:     query.Get("name"),
+		Status:   query.Get("status"),
+		Tags:     query["tag"],
+		Metadata: make(map[string]string),
+	}
+
+	for _, pair := range query["meta"] {
+		key, value, _ := strings.Cut(pair, ":")
+		filter.Metadata[key] = value
+	}
+
+	return filter
+}
+
+func (f serviceFilter) matches(entry common.ServiceEntry) bool {
+	if f.Name != "" && !strings.EqualFold(f.Name, entry.Name) {
+		return false
+	}
+	if f.Status != "" && f.Status != entry.Status {
+		return false
+	}
+
+	for _, tag := range f.Tags {
+		found := false
+		for _, t := range entry.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for key, value := range f.Metadata {
+		if v, ok := entry.Metadata[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// listServices returns every registered service with its current status.
+// Entries that cannot be read or decoded are logged and skipped.
+func listServices() ([]common.ServiceEntry, error) {
+	entries := []common.ServiceEntry{}
+
+	iter := rdb.Scan(ctx, 0, "service:*", 0).Iterator()
+	for iter.Next(ctx) {
+		serviceKey := iter.Val()
+
+		fields, err := rdb.HGetAll(ctx, serviceKey).Result()
+		if err != nil {
+			common.Err("Failed to get service data from Redis: %v", err)
+			continue
+		}
+
+		var entry common.ServiceEntry
+		if err := json.Unmarshal([]byte(fields["data"]), &entry.ServiceConfig); err != nil {
+			common.Err("Failed to unmarshal service data for %s: %v", serviceKey, err)
+			continue
+		}
+		entry.Status = fields["status"]
+
+		entries = append(entries, entry)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, common.Err("Error iterating through services in Redis: %v", err)
+	}
+
+	return entries, nil
+}
+
+// instancesKey returns the Redis set that groups the instance IDs of a service name.
+func instancesKey(name string) string {
+	return "instances:" + strings.ToLower(name)
+}
+
+// listInstances returns the registered instances of a service name.
+// IDs whose entry has expired or changed name are pruned from the set.
+func listInstances(name string) ([]common.ServiceEntry, error) {
+	ids, err := rdb.SMembers(ctx, instancesKey(name)).Result()
+	if err != nil {
+		return nil, common.Err("Failed to get instances of %s from Redis: %v", name, err)
+	}
+
+	entries := []common.ServiceEntry{}
+	for _, id := range ids {
+		fields, err := rdb.HGetAll(ctx, "service:"+id).Result()
+		if err != nil {
+			common.Err("Failed to get service data from Redis: %v", err)
+			continue
+		}
+		// The lease expired; collectExpiredLeases deregisters it
+		if len(fields) == 0 {
+			continue
+		}
+
+		var entry common.ServiceEntry
+		if err := json.Unmarshal([]byte(fields["data"]), &entry.ServiceConfig); err != nil || !strings.EqualFold(entry.Name, name) {
+			rdb.SRem(ctx, instancesKey(name), id)
+			continue
+		}
+		entry.Status = fields["status"]
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// instancesHandler handles GET /instances/{name}, optionally filtered by ?status=.
+func instancesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Path[len("/instances/"):]
+	if name == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := listInstances(name)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	matched := []common.ServiceEntry{}
+	for _, entry := range entries {
+		if status == "" || entry.Status == status {
+			matched = append(matched, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matched)
+}
+
+// listServicesHandler handles GET /services.
+func listServicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := listServices()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	filter := newServiceFilter(r)
+	matched := []common.ServiceEntry{}
+	for _, entry := range entries {
+		if filter.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matched)
+}
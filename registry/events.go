@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/nesiler/cestx/rabbitmq"
+	"github.com/streadway/amqp"
+)
+
+// Registry events are fanned out to /watch subscribers and published to
+// the registry exchange. A single dispatcher goroutine owns the AMQP
+// channel, since channels must not be shared between goroutines; it opens
+// a new one when the connection comes back after a broker restart.
+
+var (
+	events      = make(chan rabbitmq.RegistryMessage, 256)
+	amqpConn    *rabbitmq.ManagedConnection
+	subscribers = make(map[chan rabbitmq.RegistryMessage]struct{})
+	subsMu      sync.Mutex
+)
+
+// initEvents starts connecting to RabbitMQ and starts the event dispatcher.
+// The registry keeps working without a broker; events then only reach
+// /watch until the connection, retried in the background, comes up.
+func initEvents() {
+	cfg, err := common.LoadRabbitMQConfig()
+	if err != nil {
+		common.Warn("Registry events will not be published to RabbitMQ: %v", err)
+	} else {
+		amqpConn = rabbitmq.DialManagedConnection(cfg, rabbitmq.DeclareTopology)
+	}
+
+	go dispatchEvents()
+}
+
+// closeEvents closes the RabbitMQ connection used for events.
+func closeEvents() {
+	if amqpConn != nil {
+		amqpConn.Close()
+	}
+}
+
+func dispatchEvents() {
+	var ch *amqp.Channel
+	for event := range events {
+		subsMu.Lock()
+		for sub := range subscribers {
+			select {
+			case sub <- event:
+			default:
+				// Slow watcher; drop rather than stall the registry
+			}
+		}
+		subsMu.Unlock()
+
+		if amqpConn != nil {
+			ch = publishEvent(ch, event)
+		}
+	}
+}
+
+// publishEvent publishes event on ch, opening a channel first if needed, and
+// returns the channel to use for the next event. Events raised while the
+// broker is unreachable are dropped; /watch subscribers still receive them.
+func publishEvent(ch *amqp.Channel, event rabbitmq.RegistryMessage) *amqp.Channel {
+	if ch == nil {
+		var err error
+		if ch, err = amqpConn.Channel(); err != nil {
+			common.Warn("Registry event %s not published: %v", event.Event, err)
+			return nil
+		}
+	}
+
+	if err := rabbitmq.Publish(ch, rabbitmq.ExchangeRegistry, string(event.Event), event); err != nil {
+		common.Warn("Failed to publish registry event: %v", err)
+		ch.Close() // a failed publish may have closed the channel; open a new one next time
+		return nil
+	}
+	return ch
+}
+
+// emitEvent queues a registry event without blocking the caller.
+func emitEvent(event rabbitmq.RegistryMessage) {
+	event.Timestamp = time.Now()
+	select {
+	case events <- event:
+	default:
+		common.Warn("Registry event queue full, dropping %s for %s", event.Event, event.ServiceID)
+	}
+}
+
+func subscribe() chan rabbitmq.RegistryMessage {
+	sub := make(chan rabbitmq.RegistryMessage, 32)
+	subsMu.Lock()
+	subscribers[sub] = struct{}{}
+	subsMu.Unlock()
+	return sub
+}
+
+func unsubscribe(sub chan rabbitmq.RegistryMessage) {
+	subsMu.Lock()
+	delete(subscribers, sub)
+	subsMu.Unlock()
+}
+
+// watchHandler handles GET /watch, streaming registry events as Server-Sent Events.
+// Optional ?id= and ?name= query parameters restrict the stream to one service.
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	serviceID := r.URL.Query().Get("id")
+	name := r.URL.Query().Get("name")
+
+	sub := subscribe()
+	defer unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comments keep idle connections open through proxies
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-sub:
+			if serviceID != "" && event.ServiceID != serviceID {
+				continue
+			}
+			if name != "" && !strings.EqualFold(event.Name, name) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/nesiler/cestx/common"
+	"github.com/nesiler/cestx/rabbitmq"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -23,29 +25,86 @@ func registerServiceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	registerService(service)
-	scheduleHealthCheck(service)
+	if !authorizedAs(r, service.ID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
+	if err := registerService(service); err != nil {
+		http.Error(w, "Failed to register service", http.StatusInternalServerError)
+		return
+	}
+
+	lease, err := grantLease(service)
+	if err != nil {
+		http.Error(w, "Failed to grant lease", http.StatusInternalServerError)
+		return
+	}
+
+	// Leased services report liveness through heartbeats instead of being polled
+	if service.LeaseTTL != "" {
+		stopHealthCheck(service.ID)
+	} else {
+		scheduleHealthCheck(service)
+	}
+
+	registrations.WithLabelValues(service.ID, service.Name).Inc()
+	setServiceUp(service.ID, service.Name, "unknown")
+
+	emitEvent(rabbitmq.RegistryMessage{
+		Event:     rabbitmq.RegistryRegistered,
+		ServiceID: service.ID,
+		Name:      service.Name,
+		Status:    "unknown",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lease)
 }
 
-func registerService(service common.ServiceConfig) {
+func registerService(service common.ServiceConfig) error {
 	common.Info("Registering service: %s", service.Name)
-	common.SendMessageToTelegram("**REGISTRY** ::: Registering service: " + service.Name)
+	common.SendMessageToTelegram("REGISTRY ::: Registering service: " + service.Name)
 
 	serviceData, err := json.Marshal(service)
 	if err != nil {
-		common.Err("Failed to marshal service data: %v", err)
-		return
+		return common.Err("Failed to marshal service data: %v", err)
 	}
 
 	common.Warn("Service data: %s", serviceData)
 
-	err = rdb.HSet(ctx, "service:"+service.ID, map[string]interface{}{
-		"data":   serviceData,
-		"status": "unknown", // Initial status is unknown
-	}).Err()
-	common.FailError(err, "Redis error: %v")
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, "service:"+service.ID, map[string]interface{}{
+		"data":     serviceData,
+		"status":   "unknown", // Initial status is unknown
+		"lastSeen": time.Now().Unix(),
+	})
+	pipe.SAdd(ctx, instancesKey(service.Name), service.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return common.Err("Redis error: %v", err)
+	}
+	return nil
+}
+
+// serviceHandler dispatches /service/{id} by method, and /service/{id}/history.
+func serviceHandler(w http.ResponseWriter, r *http.Request) {
+	if serviceID, ok := strings.CutSuffix(r.URL.Path[len("/service/"):], "/history"); ok {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		getHistoryHandler(w, r, serviceID)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getServiceHandler(w, r)
+	case http.MethodDelete:
+		requireSignature(deregisterServiceHandler)(w, r)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
 }
 
 func getServiceHandler(w http.ResponseWriter, r *http.Request) {
@@ -64,119 +123,129 @@ func getServiceHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(serviceData))
 }
 
-func getConfigHandler(w http.ResponseWriter, r *http.Request) {
-	configType := r.URL.Path[len("/config/"):]
-
-	var configData []byte
-	var err error
-
-	switch configType {
-	case "postgresql":
-		configData, err = json.Marshal(common.LoadPostgreSQLConfig())
-	case "rabbitmq":
-		configData, err = json.Marshal(common.LoadRabbitMQConfig())
-	case "redis":
-		configData, err = json.Marshal(common.LoadRedisConfig())
-	default:
-		http.Error(w, "Invalid config type", http.StatusBadRequest)
+// deregisterServiceHandler stops the health check of a service and removes it from Redis.
+// A service may deregister itself; the ACL admin may deregister any service.
+func deregisterServiceHandler(w http.ResponseWriter, r *http.Request) {
+	serviceID := r.URL.Path[len("/service/"):]
+	if serviceID == "" {
+		http.Error(w, "Service ID is required", http.StatusBadRequest)
+		return
+	}
+	if !authorizedAs(r, serviceID) && !callerIsAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
+	deleted, err := deregisterService(serviceID)
 	if err != nil {
-		http.Error(w, "Error fetching config", http.StatusInternalServerError)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Service not found", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(configData)
+	common.SendMessageToTelegram("REGISTRY ::: Deregistered service: " + serviceID)
+
+	w.WriteHeader(http.StatusOK)
 }
 
-func scheduleHealthCheck(service common.ServiceConfig) {
-	common.Info("Scheduling health check for service: %s", service.Name)
-
-	// Define the health check function
-	healthCheckFunc := func() {
-		// Construct the health check URL
-		healthCheckURL := fmt.Sprintf("http://%s:%d%s", service.Address, service.Port, service.HealthCheck.Endpoint)
-		common.Warn("Health check URL: %s", healthCheckURL)
-
-		// Perform the health check request
-		resp, err := http.Get(healthCheckURL)
-		if err != nil {
-			common.Warn("Health check failed for service %s: %v", service.Name, err)
-			// Update service status in Redis to "unhealthy"
-			updateServiceStatus(service.ID, "unhealthy")
-			return
-		}
-		defer resp.Body.Close()
-
-		// Check for a successful status code (200-299)
-		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-			common.Info("Health check successful for service: %s", service.Name)
-			updateServiceStatus(service.ID, "healthy")
-		} else {
-			common.Warn("Health check failed for service %s: Status Code %d", service.Name, resp.StatusCode)
-			updateServiceStatus(service.ID, "unhealthy")
+// deregisterService stops the health check of a service and removes all its keys.
+// It reports whether the service was registered.
+func deregisterService(serviceID string) (bool, error) {
+	stopHealthCheck(serviceID)
+	revokeLease(serviceID)
+
+	// Drop the instance from its service group
+	var service common.ServiceConfig
+	if serviceData, err := rdb.HGet(ctx, "service:"+serviceID, "data").Result(); err == nil {
+		if json.Unmarshal([]byte(serviceData), &service) == nil {
+			rdb.SRem(ctx, instancesKey(service.Name), serviceID)
 		}
 	}
 
-	// Schedule the health check to run every 30 seconds
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		for {
-			<-ticker.C
-			healthCheckFunc()
-		}
-	}()
+	deleted, err := rdb.Del(ctx, "service:"+serviceID, historyKey(serviceID)).Result()
+	if err != nil {
+		return false, common.Err("Failed to delete service from Redis: %v", err)
+	}
+	if deleted == 0 {
+		return false, nil
+	}
+
+	forgetService(serviceID, service.Name)
+
+	common.Info("Deregistered service: %s", serviceID)
+	return true, nil
 }
 
+// forgetService drops the metrics of a removed service and announces its removal.
+func forgetService(serviceID, name string) {
+	forgetServiceMetrics(serviceID)
+
+	emitEvent(rabbitmq.RegistryMessage{
+		Event:     rabbitmq.RegistryDeregistered,
+		ServiceID: serviceID,
+		Name:      name,
+	})
+}
+
+// updateServiceStatus stores the status of a service and emits an event when it changed.
 func updateServiceStatus(serviceID, status string) {
-	err := rdb.HSet(ctx, "service:"+serviceID, "status", status).Err()
+	serviceKey := "service:" + serviceID
+
+	fields, err := rdb.HMGet(ctx, serviceKey, "data", "status").Result()
+	if err != nil {
+		common.Err("Failed to get service status from Redis: %v", err)
+		return
+	}
+	// The entry is gone (deregistered or lease expired); don't recreate it
+	if fields[0] == nil {
+		return
+	}
+
+	err = rdb.HSet(ctx, serviceKey, "status", status).Err()
 	if err != nil {
 		common.Err("Failed to update service status in Redis: %v", err)
+		return
 	}
-}
 
-func checkUp() {
-	common.Info("Checking up services...")
+	var service common.ServiceConfig
+	if data, ok := fields[0].(string); ok {
+		json.Unmarshal([]byte(data), &service)
+	}
+	setServiceUp(serviceID, service.Name, status)
 
-	// Iterate through all registered services in Redis
-	iter := rdb.Scan(ctx, 0, "service:*", 0).Iterator()
-	for iter.Next(ctx) {
-		serviceKey := iter.Val()
+	previous, _ := fields[1].(string)
+	if previous == status {
+		return
+	}
 
-		// Retrieve service data from Redis
-		serviceData, err := rdb.HGet(ctx, serviceKey, "data").Result()
-		if err != nil {
-			common.Err("Failed to get service data from Redis: %v", err)
-			continue // Skip to the next service
-		}
+	emitEvent(rabbitmq.RegistryMessage{
+		Event:          rabbitmq.RegistryStatus,
+		ServiceID:      serviceID,
+		Name:           service.Name,
+		Status:         status,
+		PreviousStatus: previous,
+	})
+}
 
-		var service common.ServiceConfig
-		err = json.Unmarshal([]byte(serviceData), &service)
-		if err != nil {
-			common.Err("Failed to unmarshal service data: %v", err)
-			continue
-		}
+func checkUp() {
+	common.Info("Checking up services...")
 
-		// Get service status
-		status, err := rdb.HGet(ctx, serviceKey, "status").Result()
-		if err != nil {
-			common.Err("Failed to get service status: %v", err)
-			continue
-		}
+	services, err := listServices()
+	if err != nil {
+		return
+	}
 
+	for _, service := range services {
 		// Log the status of each service
-		common.Out("Service: %s (%s) - Status: %s", service.Name, service.ID, status)
+		common.Out("Service: %s (%s) - Status: %s", service.Name, service.ID, service.Status)
 
 		// Optionally, send a Telegram message if a service is unhealthy
-		if status == "unhealthy" {
-			message := fmt.Sprintf("**REGISTRY WARNING**\nService: %s (%s) is unhealthy!", service.Name, service.ID)
+		if service.Status == "unhealthy" {
+			message := fmt.Sprintf("REGISTRY WARNING\nService: %s (%s) is unhealthy!", service.Name, service.ID)
 			common.SendMessageToTelegram(message)
 		}
 	}
-
-	if err := iter.Err(); err != nil {
-		common.Err("Error iterating through services in Redis: %v", err)
-	}
 }
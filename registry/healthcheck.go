@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nesiler/cestx/common"
+)
+
+// Registry-wide defaults used when a service.json leaves a field empty
+// or sets it to something unparsable. Overridden from the environment in main.
+var (
+	defaultHealthInterval   = 30 * time.Second
+	defaultHealthTimeout    = 5 * time.Second
+	defaultFailureThreshold = 3
+	defaultSuccessThreshold = 1
+)
+
+// probes tracks the running health-check loop of each service by ID, so a
+// re-registration replaces the old loop instead of starting another one.
+var (
+	probesMu sync.Mutex
+	probes   = make(map[string]context.CancelFunc)
+)
+
+// probeSettings is the parsed form of common.HealthCheck.
+type probeSettings struct {
+	Type             string
+	Address          string // host:port of the service
+	URL              string // HTTP probes only
+	ExpectedStatus   int
+	ExpectedBody     string
+	Command          []string
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+	SuccessThreshold int
+}
+
+// loadHealthCheckDefaults reads the registry-wide probe defaults from the environment.
+func loadHealthCheckDefaults() {
+	defaultHealthInterval = parseDuration(common.GetEnv("HEALTH_INTERVAL", ""), defaultHealthInterval)
+	defaultHealthTimeout = parseDuration(common.GetEnv("HEALTH_TIMEOUT", ""), defaultHealthTimeout)
+	defaultFailureThreshold = common.GetEnvAsInt("HEALTH_FAILURE_THRESHOLD", defaultFailureThreshold)
+	defaultSuccessThreshold = common.GetEnvAsInt("HEALTH_SUCCESS_THRESHOLD", defaultSuccessThreshold)
+	allowExecProbes = common.GetEnvAsBool("HEALTH_ALLOW_EXEC", false)
+
+	amqpProbeUsername = common.GetEnv("RABBITMQ_USERNAME", "")
+	if amqpProbeUsername == "" {
+		amqpProbeUsername = "guest"
+	}
+	amqpProbePassword = common.GetEnv("RABBITMQ_PASSWORD", "")
+}
+
+// parseDuration parses a duration string, falling back to def when it is
+// empty, invalid or not positive.
+func parseDuration(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		common.Warn("Invalid duration %q, using %v", value, def)
+		return def
+	}
+	return d
+}
+
+// newProbeSettings builds the probe settings for a service, applying defaults.
+func newProbeSettings(service common.ServiceConfig) probeSettings {
+	hc := service.HealthCheck
+	settings := probeSettings{
+		Type:             strings.ToLower(hc.Type),
+		Address:          net.JoinHostPort(service.Address, strconv.Itoa(service.Port)),
+		URL:              "http://" + net.JoinHostPort(service.Address, strconv.Itoa(service.Port)) + hc.Endpoint,
+		ExpectedStatus:   hc.ExpectedStatus,
+		ExpectedBody:     hc.ExpectedBody,
+		Command:          hc.Command,
+		Interval:         parseDuration(hc.Interval, defaultHealthInterval),
+		Timeout:          parseDuration(hc.Timeout, defaultHealthTimeout),
+		FailureThreshold: hc.FailureThreshold,
+		SuccessThreshold: hc.SuccessThreshold,
+	}
+
+	if settings.Type == "" {
+		settings.Type = probeHTTP
+	}
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = defaultFailureThreshold
+	}
+	if settings.SuccessThreshold <= 0 {
+		settings.SuccessThreshold = defaultSuccessThreshold
+	}
+	// A probe that outlives its interval would overlap with the next one
+	if settings.Timeout > settings.Interval {
+		settings.Timeout = settings.Interval
+	}
+
+	return settings
+}
+
+// probe runs a single health check of the configured type, bounded by the timeout.
+func probe(parent context.Context, settings probeSettings) error {
+	run, ok := probers[settings.Type]
+	if !ok {
+		return fmt.Errorf("unknown probe type %q", settings.Type)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, settings.Timeout)
+	defer cancel()
+
+	return run(ctx, settings)
+}
+
+// scheduleHealthCheck starts probing a service, replacing any loop
+// already running for the same service ID.
+func scheduleHealthCheck(service common.ServiceConfig) {
+	settings := newProbeSettings(service)
+
+	probesMu.Lock()
+	if cancel, ok := probes[service.ID]; ok {
+		common.Info("Replacing existing health check for service: %s", service.ID)
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	probes[service.ID] = cancel
+	probesMu.Unlock()
+
+	common.Info("Scheduling health check for service: %s (every %v, timeout %v)", service.Name, settings.Interval, settings.Timeout)
+
+	// Consecutive results; the status only flips once a threshold is reached
+	var failures, successes int
+	status := "unknown"
+
+	healthCheckFunc := func() {
+		start := time.Now()
+		err := probe(ctx, settings)
+		if ctx.Err() != nil {
+			return // replaced or deregistered while probing
+		}
+		latency := time.Since(start)
+		recordProbe(service.ID, sourceProbe, latency, err)
+		observeProbe(service.ID, service.Name, settings.Type, latency, err)
+		if err == nil {
+			touchService(service.ID)
+		}
+		if err != nil {
+			common.Warn("Health check failed for service %s: %v", service.Name, err)
+			successes = 0
+			failures++
+			if failures >= settings.FailureThreshold && status != "unhealthy" {
+				status = "unhealthy"
+				updateServiceStatus(service.ID, status)
+			}
+			return
+		}
+
+		common.Info("Health check successful for service: %s", service.Name)
+		failures = 0
+		successes++
+		if successes >= settings.SuccessThreshold && status != "healthy" {
+			status = "healthy"
+			updateServiceStatus(service.ID, status)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(settings.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				common.Info("Health check stopped for service: %s", service.Name)
+				return
+			case <-ticker.C:
+				healthCheckFunc()
+			}
+		}
+	}()
+}
+
+// stopHealthCheck stops the health-check loop of a service.
+// It reports whether a loop was running.
+func stopHealthCheck(serviceID string) bool {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+
+	cancel, ok := probes[serviceID]
+	if !ok {
+		return false
+	}
+	cancel()
+	delete(probes, serviceID)
+	return true
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/redis/go-redis/v9"
+)
+
+// Every probe and heartbeat result is appended to a capped Redis stream
+// per service. Stream IDs carry the millisecond timestamp, so a time
+// window maps directly onto an XRANGE.
+
+// historySize caps each stream; the default keeps a day of 30s probes.
+var historySize int64 = 2880
+
+// Sources of history records
+const (
+	sourceProbe     = "probe"
+	sourceHeartbeat = "heartbeat"
+)
+
+// probeRecord is a single entry of a service's status history.
+type probeRecord struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMs float64   `json:"latency_ms,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// windowStats summarises the history over one window.
+type windowStats struct {
+	Window        string  `json:"window"`
+	Samples       int     `json:"samples"`
+	Uptime        float64 `json:"uptime"` // Percentage of healthy samples
+	MeanLatencyMs float64 `json:"mean_latency_ms"`
+}
+
+type historyResponse struct {
+	ServiceID string        `json:"service_id"`
+	Stats     []windowStats `json:"stats"`
+	Entries   []probeRecord `json:"entries"`
+}
+
+func historyKey(serviceID string) string {
+	return "history:" + serviceID
+}
+
+// loadHistoryDefaults reads the history size from the environment.
+func loadHistoryDefaults() {
+	historySize = int64(common.GetEnvAsInt("HISTORY_SIZE", int(historySize)))
+}
+
+// recordProbe appends a result to the history of a service.
+func recordProbe(serviceID, source string, latency time.Duration, probeErr error) {
+	values := map[string]interface{}{
+		"source":  source,
+		"healthy": probeErr == nil,
+	}
+	if source == sourceProbe {
+		values["latency_ms"] = float64(latency.Microseconds()) / 1000
+	}
+	if probeErr != nil {
+		values["error"] = probeErr.Error()
+	}
+
+	err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: historyKey(serviceID),
+		MaxLen: historySize,
+		Approx: true,
+		Values: values,
+	}).Err()
+	if err != nil {
+		common.Err("Failed to record history for service %s: %v", serviceID, err)
+	}
+}
+
+// parseRecord converts a stream message to a probeRecord.
+func parseRecord(msg redis.XMessage) probeRecord {
+	var record probeRecord
+
+	if ms, err := strconv.ParseInt(strings.SplitN(msg.ID, "-", 2)[0], 10, 64); err == nil {
+		record.Time = time.UnixMilli(ms)
+	}
+	record.Source, _ = msg.Values["source"].(string)
+	record.Error, _ = msg.Values["error"].(string)
+	if healthy, ok := msg.Values["healthy"].(string); ok {
+		record.Healthy = healthy == "1"
+	}
+	if latency, ok := msg.Values["latency_ms"].(string); ok {
+		record.LatencyMs, _ = strconv.ParseFloat(latency, 64)
+	}
+
+	return record
+}
+
+// computeStats summarises the records newer than since.
+// Mean latency only covers active probes, heartbeats carry none.
+func computeStats(window time.Duration, since time.Time, records []probeRecord) windowStats {
+	stats := windowStats{Window: window.String()}
+
+	var healthy, probes int
+	var latency float64
+	for _, record := range records {
+		if record.Time.Before(since) {
+			continue
+		}
+		stats.Samples++
+		if record.Healthy {
+			healthy++
+		}
+		if record.Source == sourceProbe {
+			probes++
+			latency += record.LatencyMs
+		}
+	}
+
+	if stats.Samples > 0 {
+		stats.Uptime = float64(healthy) / float64(stats.Samples) * 100
+	}
+	if probes > 0 {
+		stats.MeanLatencyMs = latency / float64(probes)
+	}
+
+	return stats
+}
+
+// getHistoryHandler handles GET /service/{id}/history.
+// ?window= may be repeated (default 1h and 24h); ?limit= caps the returned entries (default 100).
+func getHistoryHandler(w http.ResponseWriter, r *http.Request, serviceID string) {
+	query := r.URL.Query()
+
+	windows := []time.Duration{time.Hour, 24 * time.Hour}
+	if values := query["window"]; len(values) > 0 {
+		windows = windows[:0]
+		for _, value := range values {
+			window, err := time.ParseDuration(value)
+			if err != nil || window <= 0 {
+				http.Error(w, "Invalid window: "+value, http.StatusBadRequest)
+				return
+			}
+			windows = append(windows, window)
+		}
+	}
+
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	longest := windows[0]
+	for _, window := range windows {
+		if window > longest {
+			longest = window
+		}
+	}
+
+	now := time.Now()
+	start := strconv.FormatInt(now.Add(-longest).UnixMilli(), 10)
+	msgs, err := rdb.XRange(ctx, historyKey(serviceID), start, "+").Result()
+	if err != nil {
+		common.Err("Failed to read history for service %s: %v", serviceID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	records := make([]probeRecord, 0, len(msgs))
+	for _, msg := range msgs {
+		records = append(records, parseRecord(msg))
+	}
+
+	response := historyResponse{ServiceID: serviceID, Entries: []probeRecord{}}
+	for _, window := range windows {
+		response.Stats = append(response.Stats, computeStats(window, now.Add(-window), records))
+	}
+
+	// Newest entries first
+	for i := len(records) - 1; i >= 0 && len(response.Entries) < limit; i-- {
+		response.Entries = append(response.Entries, records[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
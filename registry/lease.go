@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nesiler/cestx/common"
+	"github.com/redis/go-redis/v9"
+)
+
+// Leases let a service report liveness by pushing heartbeats instead of
+// being polled. The lease key maps a lease ID to its service ID; both the
+// lease key and the service:{id} hash carry the lease TTL, so Redis drops
+// the registration on its own once the heartbeats stop. The rest of the
+// registration (instance set entry, history, metrics) is cleaned up by
+// collectExpiredLeases, which also emits the deregistered event.
+
+// expireScript removes an instance whose service:{id} entry has expired
+// from its instance set and deletes its history. It does nothing if the
+// service registered again in the meantime, and returns 1 if it removed it.
+var expireScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('DEL', KEYS[2])
+return redis.call('SREM', KEYS[3], ARGV[1])
+`)
+
+// grantLease creates a new lease for a service, revoking the previous one.
+// Services without a LeaseTTL get a lease that never expires.
+func grantLease(service common.ServiceConfig) (*common.Lease, error) {
+	serviceKey := "service:" + service.ID
+	revokeLease(service.ID)
+
+	ttl := time.Duration(0)
+	if service.LeaseTTL != "" {
+		ttl = parseDuration(service.LeaseTTL, defaultHealthInterval)
+	}
+
+	lease := &common.Lease{
+		ID:        uuid.NewString(),
+		ServiceID: service.ID,
+	}
+	if ttl > 0 {
+		lease.TTL = ttl.String()
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, "lease:"+lease.ID, service.ID, ttl)
+	pipe.HSet(ctx, serviceKey, "lease", lease.ID)
+	if ttl > 0 {
+		pipe.Expire(ctx, serviceKey, ttl)
+	} else {
+		pipe.Persist(ctx, serviceKey)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, common.Err("Failed to store lease for service %s: %v", service.ID, err)
+	}
+
+	return lease, nil
+}
+
+// collectExpiredLeases deregisters the instances whose lease expired. They
+// are found as members of the instances:* sets without a service:{id} entry.
+// The original name is gone with the entry, so the deregistered event
+// carries the lower-cased name of the instance set.
+func collectExpiredLeases() {
+	iter := rdb.Scan(ctx, 0, instancesKey("*"), 0).Iterator()
+	for iter.Next(ctx) {
+		setKey := iter.Val()
+		name := strings.TrimPrefix(setKey, instancesKey(""))
+
+		ids, err := rdb.SMembers(ctx, setKey).Result()
+		if err != nil {
+			common.Err("Failed to get instances of %s from Redis: %v", name, err)
+			continue
+		}
+		for _, id := range ids {
+			removed, err := expireScript.Run(ctx, rdb, []string{"service:" + id, historyKey(id), setKey}, id).Int()
+			if err != nil {
+				common.Err("Failed to remove expired service %s: %v", id, err)
+				continue
+			}
+			if removed == 0 {
+				continue
+			}
+
+			stopHealthCheck(id)
+			forgetService(id, name)
+			common.Warn("Lease of service %s expired, deregistered it", id)
+			common.SendMessageToTelegram("REGISTRY ::: Lease expired for service: " + id)
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		common.Err("Error iterating through instances in Redis: %v", err)
+	}
+}
+
+// revokeLease deletes the lease currently held by a service, if any.
+func revokeLease(serviceID string) {
+	leaseID, err := rdb.HGet(ctx, "service:"+serviceID, "lease").Result()
+	if err != nil || leaseID == "" {
+		return
+	}
+	if err := rdb.Del(ctx, "lease:"+leaseID).Err(); err != nil {
+		common.Err("Failed to delete lease %s: %v", leaseID, err)
+	}
+}
+
+// renewLeaseHandler handles PUT /lease/{leaseID} heartbeats.
+// It extends the lease and the service entry by the lease TTL and marks the service healthy.
+func renewLeaseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	leaseID := r.URL.Path[len("/lease/"):]
+	serviceID, err := rdb.Get(ctx, "lease:"+leaseID).Result()
+	if err == redis.Nil {
+		http.Error(w, "Lease not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !authorizedAs(r, serviceID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	serviceKey := "service:" + serviceID
+	serviceData, err := rdb.HGet(ctx, serviceKey, "data").Result()
+	if err == redis.Nil {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var service common.ServiceConfig
+	if err := json.Unmarshal([]byte(serviceData), &service); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	lease := common.Lease{ID: leaseID, ServiceID: serviceID}
+	if service.LeaseTTL != "" {
+		ttl := parseDuration(service.LeaseTTL, defaultHealthInterval)
+		lease.TTL = ttl.String()
+
+		pipe := rdb.TxPipeline()
+		pipe.Expire(ctx, "lease:"+leaseID, ttl)
+		pipe.Expire(ctx, serviceKey, ttl)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.Err("Failed to renew lease %s: %v", leaseID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		updateServiceStatus(serviceID, "healthy")
+		recordProbe(serviceID, sourceHeartbeat, 0, nil)
+		touchService(serviceID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lease)
+}
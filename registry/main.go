@@ -7,6 +7,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/nesiler/cestx/common"
 	"github.com/nesiler/cestx/redis"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	rc "github.com/redis/go-redis/v9"
 	"github.com/robfig/cron/v3"
 )
@@ -21,41 +22,73 @@ func main() {
 	godotenv.Load("../.env")
 	godotenv.Load(".env")
 
+	common.InitLogger("registry")
+
+	shutdownTracing, err := common.InitTracing("registry")
+	common.FailError(err, "Failed to set up tracing: %v", err)
+	defer shutdownTracing(context.Background())
+
 	common.PYTHON_API_HOST = common.GetEnv("PYTHON_API_HOST", "http://192.168.4.99") // default value is your local IP
 	common.TELEGRAM_TOKEN = common.GetEnv("TELEGRAM_TOKEN", "")
 	common.CHAT_ID = common.GetEnv("CHAT_ID", "")
 
-	common.SendMessageToTelegram("**REGISTRY** ::: Service starting...")
+	common.SendMessageToTelegram("REGISTRY ::: Service starting...")
 
 	// Initialize Redis client
-	var err error
-	cfg := common.LoadRedisConfig()
+	cfg, err := common.LoadRedisConfig()
+	common.FailError(err, "%v", err)
 	rdb, err = redis.NewRedisClient(cfg)
 	if err != nil {
 		common.Fatal("Failed to connect to Redis: %v", err)
 	}
 	defer redis.Close(rdb)
 
-	common.SendMessageToTelegram("**REGISTRY** ::: Redis client initialized")
+	loadHealthCheckDefaults()
+	loadHistoryDefaults()
+	loadAuth()
+	loadRecoveryDefaults()
+
+	initEvents()
+	defer closeEvents()
+
+	restoreHealthChecks()
+
+	common.SendMessageToTelegram("REGISTRY ::: Redis client initialized")
 
 	// Register routes and start server
-	http.HandleFunc("/register", registerServiceHandler)
-	http.HandleFunc("/service/", getServiceHandler)
-	http.HandleFunc("/config/", getConfigHandler)
-	http.HandleFunc("/health", common.HealthHandler()) // Health check endpoint
+	http.HandleFunc("/register", requireSignature(registerServiceHandler))
+	http.HandleFunc("/service/", serviceHandler)
+	http.HandleFunc("/services", listServicesHandler)
+	http.HandleFunc("/instances/", instancesHandler)
+	http.HandleFunc("/lease/", requireSignature(renewLeaseHandler))
+	http.HandleFunc("/watch", watchHandler)
+	http.HandleFunc("/config/", requireSignature(configHandler))
+	// Health check endpoints; the registry is only ready while Redis answers
+	health := common.NewHealth()
+	health.Register("redis", func(ctx context.Context) error {
+		return redis.Ping(ctx, rdb)
+	})
+	health.Handle(http.DefaultServeMux)
+	http.Handle("/metrics", promhttp.Handler()) // Prometheus metrics
 
 	go func() {
-		c.AddFunc("@every 15s", checkUp) // Check service health every 15 seconds
+		c.AddFunc("@every 15s", checkUp)              // Check service health every 15 seconds
+		c.AddFunc("@every 1m", collectStaleServices)  // Remove services not seen within the grace period
+		c.AddFunc("@every 15s", collectExpiredLeases) // Deregister services whose lease expired
 		c.Start()
 	}()
 
 	currentHost, err := common.ExternalIP()
-	common.FailError(err, "")
+	if err != nil {
+		// Not fatal: the registry listens on all interfaces anyway
+		common.Warn("Failed to get external IP: %v", err)
+		currentHost = "0.0.0.0"
+	}
 
 	common.Ok("Registry server started on: %s", currentHost)
-	common.SendMessageToTelegram("**REGISTRY** ::: Server started on: " + currentHost)
+	common.SendMessageToTelegram("REGISTRY ::: Server started on: " + currentHost)
 
-	err = http.ListenAndServe(":3434", nil)
+	err = http.ListenAndServe(":3434", common.TraceHandler(http.DefaultServeMux, "registry"))
 	common.FailError(err, "")
 
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics served on /metrics, labeled by service ID and name.
+var (
+	serviceUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "registry_service_up",
+		Help: "Whether the service is healthy (1) or not (0).",
+	}, []string{"id", "name"})
+
+	probeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "registry_probe_duration_seconds",
+		Help:    "Duration of health check probes.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"id", "name", "type"})
+
+	probeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_probe_failures_total",
+		Help: "Number of failed health check probes.",
+	}, []string{"id", "name", "type"})
+
+	registrations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_registrations_total",
+		Help: "Number of registrations received.",
+	}, []string{"id", "name"})
+)
+
+// observeProbe records the duration and outcome of a probe.
+func observeProbe(id, name, probeType string, duration time.Duration, err error) {
+	probeDuration.WithLabelValues(id, name, probeType).Observe(duration.Seconds())
+	if err != nil {
+		probeFailures.WithLabelValues(id, name, probeType).Inc()
+	}
+}
+
+// setServiceUp mirrors a service status into the up gauge.
+func setServiceUp(id, name, status string) {
+	value := 0.0
+	if status == "healthy" {
+		value = 1
+	}
+	serviceUp.WithLabelValues(id, name).Set(value)
+}
+
+// forgetServiceMetrics drops the series of a deregistered service. They are
+// matched by ID alone, as the name of an expired entry is no longer known.
+func forgetServiceMetrics(id string) {
+	labels := prometheus.Labels{"id": id}
+	serviceUp.DeletePartialMatch(labels)
+	registrations.DeletePartialMatch(labels)
+	probeDuration.DeletePartialMatch(labels)
+	probeFailures.DeletePartialMatch(labels)
+}
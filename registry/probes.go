@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nesiler/cestx/common"
+	rc "github.com/redis/go-redis/v9"
+	"github.com/streadway/amqp"
+)
+
+// Probe types accepted in HealthCheck.Type
+const (
+	probeHTTP     = "http"
+	probeTCP      = "tcp"
+	probeAMQP     = "amqp"
+	probeRedis    = "redis"
+	probePostgres = "postgres"
+	probeExec     = "exec"
+)
+
+// probeFunc runs one check; ctx carries the probe timeout.
+type probeFunc func(ctx context.Context, settings probeSettings) error
+
+// probers maps a probe type to its implementation. Backend probes reach the
+// service address with the credentials from the registry's own environment,
+// so no secrets have to be put in service.json.
+var probers = map[string]probeFunc{
+	probeHTTP:     httpProbe,
+	probeTCP:      tcpProbe,
+	probeAMQP:     amqpProbe,
+	probeRedis:    redisProbe,
+	probePostgres: postgresProbe,
+	probeExec:     execProbe,
+}
+
+// AMQP probe credentials, RABBITMQ_USERNAME and RABBITMQ_PASSWORD. They are
+// read on their own: the probe dials the service address, so the registry
+// needs no RABBITMQ_URL for it.
+var amqpProbeUsername, amqpProbePassword string
+
+// allowExecProbes enables exec probes. They run commands on the registry
+// host, so they stay off unless HEALTH_ALLOW_EXEC=true.
+var allowExecProbes bool
+
+// httpProbe expects the configured status (any 2xx by default) and,
+// if set, a body containing ExpectedBody.
+func httpProbe(ctx context.Context, settings probeSettings) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, settings.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if settings.ExpectedStatus != 0 {
+		if resp.StatusCode != settings.ExpectedStatus {
+			return fmt.Errorf("status code %d, expected %d", resp.StatusCode, settings.ExpectedStatus)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	if settings.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+		if !strings.Contains(string(body), settings.ExpectedBody) {
+			return fmt.Errorf("body does not contain %q", settings.ExpectedBody)
+		}
+	}
+
+	return nil
+}
+
+func tcpProbe(ctx context.Context, settings probeSettings) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", settings.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// amqpProbe completes the AMQP handshake, which also checks the credentials.
+func amqpProbe(ctx context.Context, settings probeSettings) error {
+	uri := fmt.Sprintf("amqp://%s:%s@%s/", url.QueryEscape(amqpProbeUsername), url.QueryEscape(amqpProbePassword), settings.Address)
+
+	var dialer net.Dialer
+	conn, err := amqp.DialConfig(uri, amqp.Config{
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			// DialContext only bounds the TCP connect; the deadline also
+			// bounds the handshake and is cleared by amqp once it is done
+			if deadline, ok := ctx.Deadline(); ok {
+				if err := conn.SetDeadline(deadline); err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func redisProbe(ctx context.Context, settings probeSettings) error {
+	client := rc.NewClient(&rc.Options{Addr: settings.Address})
+	defer client.Close()
+	return client.Ping(ctx).Err()
+}
+
+func postgresProbe(ctx context.Context, settings probeSettings) error {
+	cfg, err := common.LoadPostgreSQLConfig()
+	if err != nil {
+		return err
+	}
+	host, port, _ := net.SplitHostPort(settings.Address)
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		host, port, cfg.User, cfg.Password, cfg.DBName)
+
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "SELECT 1")
+	return err
+}
+
+// execProbe runs a command on the registry host; exit status 0 is healthy.
+func execProbe(ctx context.Context, settings probeSettings) error {
+	if !allowExecProbes {
+		return fmt.Errorf("exec probes are disabled")
+	}
+	if len(settings.Command) == 0 {
+		return fmt.Errorf("no command configured")
+	}
+
+	output, err := exec.CommandContext(ctx, settings.Command[0], settings.Command[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
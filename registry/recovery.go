@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nesiler/cestx/common"
+	"github.com/redis/go-redis/v9"
+)
+
+// Registrations live in Redis and outlive the registry process, but the
+// probe loops don't. On startup the loops are rebuilt from the stored
+// entries, and entries that have not been seen for staleGracePeriod are
+// removed periodically. "Seen" means registered, probed successfully or
+// renewed by a heartbeat.
+
+var staleGracePeriod = 24 * time.Hour
+
+// touchScript updates lastSeen without recreating a deleted entry.
+var touchScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'lastSeen', ARGV[1])
+end
+return 0
+`)
+
+// loadRecoveryDefaults reads the stale grace period from the environment.
+func loadRecoveryDefaults() {
+	staleGracePeriod = parseDuration(common.GetEnv("STALE_GRACE_PERIOD", ""), staleGracePeriod)
+}
+
+// touchService records that a service was just seen alive.
+func touchService(serviceID string) {
+	err := touchScript.Run(ctx, rdb, []string{"service:" + serviceID}, time.Now().Unix()).Err()
+	if err != nil {
+		common.Err("Failed to update last seen for service %s: %v", serviceID, err)
+	}
+}
+
+// restoreHealthChecks recreates the probe loops of all stored registrations.
+// Leased services are skipped; Redis expires them on its own.
+func restoreHealthChecks() {
+	services, err := listServices()
+	if err != nil {
+		common.Err("Failed to restore health checks: %v", err)
+		return
+	}
+
+	restored := 0
+	for _, service := range services {
+		// Entries from before lastSeen was tracked get a full grace period from now
+		if exists, _ := rdb.HExists(ctx, "service:"+service.ID, "lastSeen").Result(); !exists {
+			touchService(service.ID)
+		}
+
+		if service.LeaseTTL != "" {
+			continue
+		}
+		scheduleHealthCheck(service.ServiceConfig)
+		restored++
+	}
+
+	common.Ok("Restored health checks for %d services", restored)
+}
+
+// collectStaleServices deregisters services not seen within the grace period.
+func collectStaleServices() {
+	services, err := listServices()
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-staleGracePeriod)
+	for _, service := range services {
+		value, err := rdb.HGet(ctx, "service:"+service.ID, "lastSeen").Result()
+		if err != nil {
+			continue
+		}
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || time.Unix(unix, 0).After(cutoff) {
+			continue
+		}
+
+		common.Warn("Service %s not seen since %v, removing it", service.ID, time.Unix(unix, 0))
+		if deleted, err := deregisterService(service.ID); err == nil && deleted {
+			common.SendMessageToTelegram("REGISTRY ::: Removed stale service: " + service.ID)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -26,40 +27,57 @@ type Template struct {
 }
 
 var (
-	minioClient    *mc.Client
-	postgresClient *gc.DB
-	amqpConn       *amqp.Connection
-	amqpChannel    *amqp.Channel
+	minioClient     *mc.Client
+	templatesBucket string // bucket of the Dockerfiles, MINIO_TEMPLATES_BUCKET
+	postgresClient  *gc.DB
+	amqpConn        *rabbitmq.ManagedConnection
 )
 
 // InitializeClients initializes the Minio, PostgreSQL and RabbitMQ clients.
-func InitializeClients() {
+// All of them are required; the template operations use each of them.
+func InitializeClients() error {
+
 	// Initialize Minio client
-	minioCfg := common.LoadMinIOConfig()
-	minioClient, _ = minio.NewMinIOClient(minioCfg)
+	minioCfg, err := common.LoadMinIOConfig()
+	if err != nil {
+		return err
+	}
+	minioClient, err = minio.NewMinIOClient(minioCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create Minio client: %w", err)
+	}
+	templatesBucket = minioCfg.TemplatesBucket
 
 	// Initialize PostgreSQL client
-	dbCfg := common.LoadPostgreSQLConfig()
-	postgresClient, _ = postgresql.NewPostgreSQLDB(dbCfg)
+	dbCfg, err := common.LoadPostgreSQLConfig()
+	if err != nil {
+		return err
+	}
+	postgresClient, err = postgresql.NewPostgreSQLDB(dbCfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
 
 	// Initialize RabbitMQ connection
-	rabbitCfg := common.LoadRabbitMQConfig()
-	common.Warn("RabbitMQ config: %+v", rabbitCfg)
-	amqpConn, _ = rabbitmq.NewConnection(rabbitCfg)
-
-	// Initialize RabbitMQ channel
-	amqpChannel, _ = amqpConn.Channel()
+	rabbitCfg, err := common.LoadRabbitMQConfig()
+	if err != nil {
+		return err
+	}
+	common.Info("RabbitMQ config: %v", rabbitCfg)
+	// Declare exchanges, queues and bindings so published messages are routed,
+	// again after every reconnect
+	amqpConn, err = rabbitmq.NewManagedConnection(rabbitCfg, rabbitmq.DeclareTopology)
+	return err
 }
 
 // UploadTemplate uploads a Dockerfile to Minio and creates a template record in PostgreSQL.
-func UploadTemplate(templateName, filePath string, userID uuid.UUID) error {
-	ctx := context.Background()
-	bucketName := "templates"
+func UploadTemplate(ctx context.Context, templateName, filePath string, userID uuid.UUID) (*models.Template, error) {
+	bucketName := templatesBucket
 
 	// 1. Upload Dockerfile to Minio
 	objectName, err := minio.UploadTemplate(ctx, minioClient, filePath, templateName, bucketName)
 	if err != nil {
-		return fmt.Errorf("failed to upload Dockerfile to Minio: %w", err)
+		return nil, fmt.Errorf("failed to upload Dockerfile to Minio: %w", err)
 	}
 
 	// 2. Create template record in PostgreSQL
@@ -73,68 +91,79 @@ func UploadTemplate(templateName, filePath string, userID uuid.UUID) error {
 
 	templateRepo := postgresql.NewTemplateRepository(postgresClient)
 	if err := templateRepo.CreateTemplate(ctx, newTemplate); err != nil {
-		return fmt.Errorf("failed to create template record: %w", err)
+		return nil, fmt.Errorf("failed to create template record: %w", err)
 	}
 
 	common.Ok("Template uploaded and registered successfully: %s", templateName)
-	return nil
+	return newTemplate, nil
 }
 
-// DeleteTemplate deletes a template from both Minio and PostgreSQL.
-func DeleteTemplate(templateName string) error {
-	ctx := context.Background()
-	bucketName := "templates"
+// DeleteTemplate deletes a template from both Minio and PostgreSQL and returns its former record.
+func DeleteTemplate(ctx context.Context, templateName string) (*models.Template, error) {
+	bucketName := templatesBucket
 
 	// 1. Get template information from PostgreSQL
 	templateRepo := postgresql.NewTemplateRepository(postgresClient)
 	template, err := templateRepo.GetTemplateByName(ctx, templateName)
 	if err != nil {
-		return fmt.Errorf("failed to get template from PostgreSQL: %w", err)
+		return nil, fmt.Errorf("failed to get template from PostgreSQL: %w", err)
 	}
 
 	// 2. Delete from Minio
 	if err := minio.DeleteTemplate(ctx, minioClient, template.Name, bucketName); err != nil {
-		return fmt.Errorf("failed to delete template from Minio: %w", err)
+		return nil, fmt.Errorf("failed to delete template from Minio: %w", err)
 	}
 
 	// 3. Delete from PostgreSQL
 	if err := templateRepo.DeleteTemplate(ctx, template.ID); err != nil {
-		return fmt.Errorf("failed to delete template record: %w", err)
+		return nil, fmt.Errorf("failed to delete template record: %w", err)
 	}
 
 	common.Ok("Template deleted successfully: %s", templateName)
-	return nil
+	return template, nil
 }
 
-// handleMessage processes RabbitMQ messages.
-func handleMessage(delivery amqp.Delivery) {
+// handleMessage processes RabbitMQ messages and returns the affected
+// template, which is sent back to callers using Call. Failed messages are
+// retried, malformed ones and those naming a missing template are parked
+// right away.
+func handleMessage(ctx context.Context, delivery amqp.Delivery) (*rabbitmq.TemplateResult, error) {
 	var templateMessage rabbitmq.TemplateMessage
 	if err := json.Unmarshal(delivery.Body, &templateMessage); err != nil {
-		common.Err("Error unmarshalling message: %v", err)
-		return
+		return nil, rabbitmq.Permanent(fmt.Errorf("error unmarshalling message: %w", err))
 	}
 
-	common.Info("Received message: %+v", templateMessage)
+	ctx = common.WithLogFields(ctx, common.FieldTemplateID, templateMessage.TemplateID.String())
+	common.Logger(ctx).Info("Received message", "event", templateMessage.Event, "name", templateMessage.Name)
 
 	// Implement logic for different template events (create, delete, ...)
+	var template *models.Template
+	var err error
 	switch templateMessage.Event {
 	case rabbitmq.TemplateCreate:
-		UploadTemplate(templateMessage.Name, templateMessage.Name, templateMessage.TemplateID)
+		template, err = UploadTemplate(ctx, templateMessage.Name, templateMessage.Name, templateMessage.TemplateID)
 	case rabbitmq.TemplateDelete:
-		DeleteTemplate(templateMessage.Name)
+		template, err = DeleteTemplate(ctx, templateMessage.Name)
 	default:
-		common.Warn("Unknown template event: %s", templateMessage.Event)
+		return nil, rabbitmq.Permanent(fmt.Errorf("unknown template event: %s", templateMessage.Event))
 	}
-}
 
-// ConsumeMessages starts consuming RabbitMQ messages for template operations.
-func ConsumeMessages() {
-	// Start consuming messages
-	err := rabbitmq.Consume(amqpChannel, rabbitmq.QueueTemplateCreate, func(delivery amqp.Delivery) error {
-		handleMessage(delivery)
-		return nil // Acknowledge message if processed successfully; otherwise return an error
-	})
+	if errors.Is(err, common.ErrNotFound) {
+		return nil, rabbitmq.Permanent(err)
+	}
 	if err != nil {
-		common.Fatal("Error consuming messages: %v", err)
+		return nil, err
+	}
+	return &rabbitmq.TemplateResult{TemplateID: template.ID, Name: template.Name, File: template.File}, nil
+}
+
+// ConsumeMessages returns a worker that consumes template messages from
+// queue until ctx is cancelled. Requests sent with Call get the resulting
+// template or the error back.
+func ConsumeMessages(queue string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return amqpConn.Consume(ctx, queue, rabbitmq.RPC(func(ctx context.Context, delivery amqp.Delivery) (interface{}, error) {
+			return handleMessage(ctx, delivery) // Acknowledged on success, retried or parked on error
+		}))
 	}
 }